@@ -0,0 +1,286 @@
+// Package config loads the typed service configuration shared by
+// inventory-service and order-service.
+//
+// Values are resolved in increasing order of precedence:
+//
+//	defaults < YAML file (--config or CONFIG_FILE) < environment < flags
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"time"
+
+	"Service-sharing-environment-project/telemetry"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	StoreKindMemory = "memory"
+	StoreKindFile   = "file"
+)
+
+// Config is the complete configuration of a service process.
+type Config struct {
+	ListenAddress string `yaml:"listen_address"`
+	// Reflection enables gRPC server reflection (e.g. for grpcurl and ghz without .proto files).
+	Reflection bool `yaml:"reflection"`
+	// HealthCheckInterval is how often order-service polls the inventory health.
+	HealthCheckInterval time.Duration `yaml:"health_check_interval"`
+
+	Telemetry    telemetry.Config `yaml:"telemetry"`
+	Timeouts     Timeouts         `yaml:"timeouts"`
+	Inventory    Inventory        `yaml:"inventory"`
+	Store        Store            `yaml:"store"`
+	Reservations Reservations     `yaml:"reservations"`
+	Orders       Orders           `yaml:"orders"`
+	Sessions     Sessions         `yaml:"sessions"`
+}
+
+// Timeouts bounds calls made by the service.
+type Timeouts struct {
+	// Request is the deadline applied to outgoing RPCs that have none.
+	Request time.Duration `yaml:"request"`
+	// Shutdown is the grace period for in-flight RPCs after SIGTERM.
+	Shutdown time.Duration `yaml:"shutdown"`
+}
+
+// Inventory describes the downstream inventory-service (used by order-service).
+type Inventory struct {
+	Endpoint string `yaml:"endpoint"`
+}
+
+// Store selects the inventory product store.
+type Store struct {
+	Kind             string        `yaml:"kind"`
+	DataDir          string        `yaml:"data_dir"`
+	SnapshotInterval time.Duration `yaml:"snapshot_interval"`
+	// SeedFile is a JSON file with the initial product catalogue; empty uses the built-in one.
+	SeedFile string `yaml:"seed_file"`
+	// LowStockThreshold is the free quantity at or below which a product counts
+	// towards the inventory_low_stock_products gauge.
+	LowStockThreshold int `yaml:"low_stock_threshold"`
+}
+
+// Orders selects the order-service order store.
+type Orders struct {
+	Kind             string        `yaml:"kind"`
+	DataDir          string        `yaml:"data_dir"`
+	SnapshotInterval time.Duration `yaml:"snapshot_interval"`
+}
+
+// Reservations configures the inventory soft reservations.
+type Reservations struct {
+	TTL time.Duration `yaml:"ttl"`
+}
+
+// Sessions configures the order-service BuildOrder sessions.
+type Sessions struct {
+	// TTL is the idle time after which a session that was neither finalized
+	// nor cancelled expires and its inventory holds are released. It should not
+	// exceed the reservations.ttl of inventory-service, or a session would keep
+	// items whose holds have already expired (BuildOrder then reconciles them).
+	TTL time.Duration `yaml:"ttl"`
+}
+
+const (
+	minSweepInterval = time.Second
+	maxSweepInterval = 30 * time.Second
+)
+
+// SweepInterval returns how often to sweep for reservations or sessions idle
+// longer than ttl: a tenth of the TTL, kept between one and thirty seconds, so
+// an entry outlives its TTL by at most that much.
+func SweepInterval(ttl time.Duration) time.Duration {
+	return min(max(ttl/10, minSweepInterval), maxSweepInterval)
+}
+
+// Defaults returns the built-in configuration for the given service.
+func Defaults(serviceName, listenAddress string) Config {
+	return Config{
+		ListenAddress:       listenAddress,
+		Reflection:          true,
+		HealthCheckInterval: 10 * time.Second,
+		Telemetry: telemetry.Config{
+			ServiceName:       serviceName,
+			LogLevel:          "info",
+			ExemplarFilter:    telemetry.ExemplarFilterTraceBased,
+			SpanBaggageKeys:   telemetry.DefaultSpanBaggageKeys,
+			MetricBaggageKeys: telemetry.DefaultMetricBaggageKeys,
+			Exporters: telemetry.ExportersConfig{
+				Traces:            telemetry.ExporterOTLP,
+				Metrics:           telemetry.ExporterOTLP,
+				Logs:              telemetry.ExporterOTLP,
+				PrometheusAddress: telemetry.DefaultPrometheusAddress,
+				// Kolektor w klastrze przyjmuje OTLP bez TLS
+				OTLP: telemetry.OTLPConfig{Protocol: telemetry.OTLPProtocolGRPC, Insecure: true},
+			},
+			Sampling: telemetry.SamplingConfig{
+				Sampler: telemetry.SamplerParentBasedAlwaysOn,
+				Ratio:   1,
+				// Finalizacja zawsze w pełni śledzona; zapytania z testów obciążeniowych w 1%
+				Overrides: map[string]float64{
+					"FinalizeOrder":         1,
+					"CheckItemAvailability": 0.01,
+				},
+				AlwaysSampleErrors: true,
+			},
+		},
+		Timeouts:  Timeouts{Request: 5 * time.Second, Shutdown: 15 * time.Second},
+		Inventory: Inventory{Endpoint: "localhost:50051"},
+		Store: Store{
+			Kind:              StoreKindMemory,
+			DataDir:           "data",
+			SnapshotInterval:  time.Minute,
+			LowStockThreshold: 10,
+		},
+		Reservations: Reservations{TTL: 15 * time.Minute},
+		Orders: Orders{
+			Kind:             StoreKindMemory,
+			DataDir:          "data",
+			SnapshotInterval: time.Minute,
+		},
+		Sessions: Sessions{TTL: 10 * time.Minute},
+	}
+}
+
+// Load resolves the configuration from defaults, the optional YAML file,
+// the environment and the command-line arguments (without the program name),
+// then validates the result.
+func Load(defaults Config, args []string) (Config, error) {
+	cfg := defaults
+	settings := cfg.settings()
+
+	fs := flag.NewFlagSet(cfg.Telemetry.ServiceName, flag.ContinueOnError)
+	configFile := fs.String("config", os.Getenv("CONFIG_FILE"), "path to a YAML configuration file (env CONFIG_FILE)")
+	// Flagi są tylko zapamiętywane – stosujemy je na końcu, po pliku i zmiennych środowiskowych
+	for _, s := range settings {
+		usage := fmt.Sprintf("%s (env %s)", s.usage, s.env)
+		if _, ok := s.value.(*boolValue); ok {
+			fs.BoolFunc(s.flag, usage, s.record)
+			continue
+		}
+		fs.Func(s.flag, usage, s.record)
+	}
+	if err := fs.Parse(args); err != nil {
+		return Config{}, err
+	}
+
+	if *configFile != "" {
+		if err := cfg.loadFile(*configFile); err != nil {
+			return Config{}, err
+		}
+	}
+	for _, s := range settings {
+		if v, ok := os.LookupEnv(s.env); ok && v != "" {
+			if err := s.value.Set(v); err != nil {
+				return Config{}, fmt.Errorf("env %s: %w", s.env, err)
+			}
+		}
+	}
+	for _, s := range settings {
+		if s.flagSet {
+			if err := s.value.Set(s.flagRaw); err != nil {
+				return Config{}, fmt.Errorf("flag -%s: %w", s.flag, err)
+			}
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read config file: %w", err)
+	}
+	if err := yaml.Unmarshal(data, c); err != nil {
+		return fmt.Errorf("parse config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// Validate reports every invalid setting at once.
+func (c Config) Validate() error {
+	var errs []error
+	if _, _, err := net.SplitHostPort(c.ListenAddress); err != nil {
+		errs = append(errs, fmt.Errorf("listen_address %q: %w", c.ListenAddress, err))
+	}
+	if c.HealthCheckInterval <= 0 {
+		errs = append(errs, errors.New("health_check_interval must be positive"))
+	}
+	if c.Telemetry.ServiceName == "" {
+		errs = append(errs, errors.New("telemetry.service_name must not be empty"))
+	}
+	if c.Telemetry.LogLevel != "" {
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(c.Telemetry.LogLevel)); err != nil {
+			errs = append(errs, fmt.Errorf("telemetry.log_level: %w", err))
+		}
+	}
+	switch c.Telemetry.ExemplarFilter {
+	case "", telemetry.ExemplarFilterTraceBased, telemetry.ExemplarFilterAlwaysOn, telemetry.ExemplarFilterAlwaysOff:
+	default:
+		errs = append(errs, fmt.Errorf("telemetry.exemplar_filter %q: expected trace_based, always_on or always_off", c.Telemetry.ExemplarFilter))
+	}
+	if err := c.Telemetry.Exporters.Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("telemetry.exporters: %w", err))
+	}
+	if err := c.Telemetry.Sampling.Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("telemetry.sampling: %w", err))
+	}
+	if c.Inventory.Endpoint == "" {
+		errs = append(errs, errors.New("inventory.endpoint must not be empty"))
+	}
+	if c.Timeouts.Request <= 0 {
+		errs = append(errs, errors.New("timeouts.request must be positive"))
+	}
+	if c.Timeouts.Shutdown <= 0 {
+		errs = append(errs, errors.New("timeouts.shutdown must be positive"))
+	}
+	switch c.Store.Kind {
+	case StoreKindMemory:
+	case StoreKindFile:
+		if c.Store.DataDir == "" {
+			errs = append(errs, errors.New("store.data_dir is required for the file store"))
+		}
+		if c.Store.SnapshotInterval <= 0 {
+			errs = append(errs, errors.New("store.snapshot_interval must be positive"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("store.kind %q: expected %q or %q", c.Store.Kind, StoreKindMemory, StoreKindFile))
+	}
+	if c.Store.LowStockThreshold < 0 {
+		errs = append(errs, errors.New("store.low_stock_threshold must not be negative"))
+	}
+	switch c.Orders.Kind {
+	case StoreKindMemory:
+	case StoreKindFile:
+		if c.Orders.DataDir == "" {
+			errs = append(errs, errors.New("orders.data_dir is required for the file store"))
+		}
+		if c.Orders.SnapshotInterval <= 0 {
+			errs = append(errs, errors.New("orders.snapshot_interval must be positive"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("orders.kind %q: expected %q or %q", c.Orders.Kind, StoreKindMemory, StoreKindFile))
+	}
+	if c.Reservations.TTL <= 0 {
+		errs = append(errs, errors.New("reservations.ttl must be positive"))
+	}
+	if c.Sessions.TTL <= 0 {
+		errs = append(errs, errors.New("sessions.ttl must be positive"))
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration: %w", errors.Join(errs...))
+	}
+	return nil
+}
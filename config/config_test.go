@@ -0,0 +1,41 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDefaultsAreValid(t *testing.T) {
+	if err := Defaults("test", ":0").Validate(); err != nil {
+		t.Fatalf("Validate(Defaults) = %v", err)
+	}
+}
+
+// Each process validates only its own TTL: inventory-service never gets
+// SESSION_TTL, so a short reservation TTL must not stop it from starting.
+func TestTTLsAreValidatedIndependently(t *testing.T) {
+	for _, args := range [][]string{
+		{"--reservation-ttl=5m"},
+		{"--session-ttl=30m"},
+	} {
+		if _, err := Load(Defaults("test", ":0"), args); err != nil {
+			t.Errorf("Load(%v): %v", args, err)
+		}
+	}
+	if _, err := Load(Defaults("test", ":0"), []string{"--session-ttl=0s"}); err == nil {
+		t.Error("Load with a zero session TTL: want error")
+	}
+}
+
+func TestSweepInterval(t *testing.T) {
+	for ttl, want := range map[time.Duration]time.Duration{
+		0:                minSweepInterval,
+		5 * time.Second:  minSweepInterval,
+		time.Minute:      6 * time.Second,
+		15 * time.Minute: maxSweepInterval,
+	} {
+		if got := SweepInterval(ttl); got != want {
+			t.Errorf("SweepInterval(%s) = %s, want %s", ttl, got, want)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// setting binds one configuration field to its flag and environment variable.
+type setting struct {
+	flag  string
+	env   string
+	usage string
+	value interface{ Set(string) error }
+
+	flagSet bool
+	flagRaw string
+}
+
+func (s *setting) record(raw string) error {
+	s.flagSet, s.flagRaw = true, raw
+	return nil
+}
+
+// settings lists every field that can be overridden from the environment or flags.
+func (c *Config) settings() []*setting {
+	return []*setting{
+		{flag: "listen", env: "LISTEN_ADDRESS", usage: "gRPC listen address", value: (*stringValue)(&c.ListenAddress)},
+		{flag: "reflection", env: "GRPC_REFLECTION", usage: "enable gRPC server reflection", value: (*boolValue)(&c.Reflection)},
+		{flag: "health-check-interval", env: "HEALTH_CHECK_INTERVAL", usage: "interval of downstream health checks", value: (*durationValue)(&c.HealthCheckInterval)},
+		{flag: "otlp-endpoint", env: "OTEL_EXPORTER_OTLP_ENDPOINT", usage: "OTLP collector endpoint, host:port or URL", value: (*stringValue)(&c.Telemetry.OTLPEndpoint)},
+		{flag: "otlp-protocol", env: "OTEL_EXPORTER_OTLP_PROTOCOL", usage: "OTLP protocol: grpc or http/protobuf", value: (*stringValue)(&c.Telemetry.Exporters.OTLP.Protocol)},
+		{flag: "otlp-insecure", env: "OTEL_EXPORTER_OTLP_INSECURE", usage: "disable TLS for a host:port OTLP endpoint", value: (*boolValue)(&c.Telemetry.Exporters.OTLP.Insecure)},
+		{flag: "otlp-certificate", env: "OTEL_EXPORTER_OTLP_CERTIFICATE", usage: "PEM file with CA certificates trusted for OTLP TLS", value: (*stringValue)(&c.Telemetry.Exporters.OTLP.Certificate)},
+		{flag: "otlp-headers", env: "OTEL_EXPORTER_OTLP_HEADERS", usage: "headers sent with OTLP exports, e.g. authorization=Bearer%20token", value: (*headerMapValue)(&c.Telemetry.Exporters.OTLP.Headers)},
+		{flag: "traces-exporter", env: "OTEL_TRACES_EXPORTER", usage: "span exporter: otlp, console or none", value: (*stringValue)(&c.Telemetry.Exporters.Traces)},
+		{flag: "metrics-exporter", env: "OTEL_METRICS_EXPORTER", usage: "metric exporter: otlp, prometheus, console or none", value: (*stringValue)(&c.Telemetry.Exporters.Metrics)},
+		{flag: "logs-exporter", env: "OTEL_LOGS_EXPORTER", usage: "log exporter: otlp or none (stdout is always written)", value: (*stringValue)(&c.Telemetry.Exporters.Logs)},
+		{flag: "prometheus-address", env: "PROMETHEUS_ADDRESS", usage: "listen address of the Prometheus /metrics endpoint", value: (*stringValue)(&c.Telemetry.Exporters.PrometheusAddress)},
+		{flag: "service-name", env: "OTEL_SERVICE_NAME", usage: "service name reported to telemetry", value: (*stringValue)(&c.Telemetry.ServiceName)},
+		{flag: "environment", env: "ENVIRONMENT", usage: "deployment environment", value: (*stringValue)(&c.Telemetry.Environment)},
+		{flag: "log-level", env: "LOG_LEVEL", usage: "minimum log level: debug, info, warn or error", value: (*stringValue)(&c.Telemetry.LogLevel)},
+		{flag: "metrics-exemplar-filter", env: "OTEL_METRICS_EXEMPLAR_FILTER", usage: "exemplar filter: trace_based, always_on or always_off", value: (*stringValue)(&c.Telemetry.ExemplarFilter)},
+		{flag: "span-baggage-keys", env: "TELEMETRY_SPAN_BAGGAGE_KEYS", usage: "comma-separated baggage keys copied to span attributes", value: (*listValue)(&c.Telemetry.SpanBaggageKeys)},
+		{flag: "metric-baggage-keys", env: "TELEMETRY_METRIC_BAGGAGE_KEYS", usage: "comma-separated baggage keys added to RPC metrics", value: (*listValue)(&c.Telemetry.MetricBaggageKeys)},
+		{flag: "traces-sampler", env: "OTEL_TRACES_SAMPLER", usage: "trace sampler, e.g. parentbased_traceidratio", value: (*stringValue)(&c.Telemetry.Sampling.Sampler)},
+		{flag: "traces-sampler-arg", env: "OTEL_TRACES_SAMPLER_ARG", usage: "sampling ratio of the traceidratio samplers", value: (*floatValue)(&c.Telemetry.Sampling.Ratio)},
+		{flag: "traces-sampler-overrides", env: "TRACES_SAMPLER_OVERRIDES", usage: "per-RPC sampling ratios, e.g. FinalizeOrder=1,CheckItemAvailability=0.01", value: (*ratioMapValue)(&c.Telemetry.Sampling.Overrides)},
+		{flag: "traces-sample-errors", env: "TRACES_ALWAYS_SAMPLE_ERRORS", usage: "export failed spans even when their trace is not sampled", value: (*boolValue)(&c.Telemetry.Sampling.AlwaysSampleErrors)},
+		{flag: "pprof-address", env: "PPROF_ADDRESS", usage: "address of the net/http/pprof endpoint, e.g. :6060", value: (*stringValue)(&c.Telemetry.Profiling.PprofAddress)},
+		{flag: "pyroscope-endpoint", env: "PYROSCOPE_SERVER_ADDRESS", usage: "Pyroscope server profiles are pushed to", value: (*stringValue)(&c.Telemetry.Profiling.PyroscopeEndpoint)},
+		{flag: "request-timeout", env: "REQUEST_TIMEOUT", usage: "deadline for outgoing RPCs", value: (*durationValue)(&c.Timeouts.Request)},
+		{flag: "shutdown-timeout", env: "SHUTDOWN_TIMEOUT", usage: "grace period for in-flight RPCs on shutdown", value: (*durationValue)(&c.Timeouts.Shutdown)},
+		{flag: "inventory-endpoint", env: "INVENTORY_SERVICE_ENDPOINT", usage: "inventory-service gRPC target", value: (*stringValue)(&c.Inventory.Endpoint)},
+		{flag: "store", env: "INVENTORY_STORE", usage: "product store: memory or file", value: (*stringValue)(&c.Store.Kind)},
+		{flag: "data-dir", env: "INVENTORY_DATA_DIR", usage: "data directory of the file store", value: (*stringValue)(&c.Store.DataDir)},
+		{flag: "snapshot-interval", env: "INVENTORY_SNAPSHOT_INTERVAL", usage: "file store snapshot interval", value: (*durationValue)(&c.Store.SnapshotInterval)},
+		{flag: "seed-file", env: "INVENTORY_SEED_FILE", usage: "JSON file with the initial product catalogue", value: (*stringValue)(&c.Store.SeedFile)},
+		{flag: "low-stock-threshold", env: "INVENTORY_LOW_STOCK_THRESHOLD", usage: "free quantity at or below which a product counts as low on stock", value: (*intValue)(&c.Store.LowStockThreshold)},
+		{flag: "order-store", env: "ORDER_STORE", usage: "order store: memory or file", value: (*stringValue)(&c.Orders.Kind)},
+		{flag: "order-data-dir", env: "ORDER_DATA_DIR", usage: "data directory of the order file store", value: (*stringValue)(&c.Orders.DataDir)},
+		{flag: "order-snapshot-interval", env: "ORDER_SNAPSHOT_INTERVAL", usage: "order file store snapshot interval", value: (*durationValue)(&c.Orders.SnapshotInterval)},
+		{flag: "reservation-ttl", env: "RESERVATION_TTL", usage: "idle time after which soft reservations expire", value: (*durationValue)(&c.Reservations.TTL)},
+		{flag: "session-ttl", env: "SESSION_TTL", usage: "idle time after which order sessions expire", value: (*durationValue)(&c.Sessions.TTL)},
+	}
+}
+
+type stringValue string
+
+func (v *stringValue) Set(s string) error {
+	*v = stringValue(s)
+	return nil
+}
+
+// listValue parses a comma-separated list; an empty string yields an empty (non-nil) list.
+type listValue []string
+
+func (v *listValue) Set(s string) error {
+	list := []string{}
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	*v = list
+	return nil
+}
+
+// ratioMapValue parses "Method=ratio,..." pairs; it replaces the whole map.
+type ratioMapValue map[string]float64
+
+func (v *ratioMapValue) Set(s string) error {
+	m := make(map[string]float64)
+	for _, pair := range strings.Split(s, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		key, raw, ok := strings.Cut(pair, "=")
+		if !ok {
+			return fmt.Errorf("expected key=value, got %q", pair)
+		}
+		ratio, err := strconv.ParseFloat(strings.TrimSpace(raw), 64)
+		if err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+		m[strings.TrimSpace(key)] = ratio
+	}
+	*v = m
+	return nil
+}
+
+// headerMapValue parses "key=value,..." pairs with URL-encoded values, as in
+// OTEL_EXPORTER_OTLP_HEADERS; it replaces the whole map.
+type headerMapValue map[string]string
+
+func (v *headerMapValue) Set(s string) error {
+	m := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		key, raw, ok := strings.Cut(pair, "=")
+		if !ok {
+			return fmt.Errorf("expected key=value, got %q", pair)
+		}
+		value, err := url.PathUnescape(strings.TrimSpace(raw))
+		if err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+		m[strings.TrimSpace(key)] = value
+	}
+	*v = m
+	return nil
+}
+
+type intValue int
+
+func (v *intValue) Set(s string) error {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return err
+	}
+	*v = intValue(n)
+	return nil
+}
+
+type floatValue float64
+
+func (v *floatValue) Set(s string) error {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return err
+	}
+	*v = floatValue(f)
+	return nil
+}
+
+type boolValue bool
+
+func (v *boolValue) Set(s string) error {
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return err
+	}
+	*v = boolValue(b)
+	return nil
+}
+
+type durationValue time.Duration
+
+func (v *durationValue) Set(s string) error {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*v = durationValue(d)
+	return nil
+}
@@ -1,3 +1,12 @@
+variable "VERSION" {
+  default = "0.1.0"
+}
+
+# Commit the images are built from, e.g. REVISION=$(git rev-parse HEAD)
+variable "REVISION" {
+  default = ""
+}
+
 group "default" {
   targets = ["order-service", "inventory-service"]
 }
@@ -5,11 +14,19 @@ group "default" {
 target "order-service" {
   context = "."
   dockerfile = "./infrastructure/docker/order-service/Dockerfile"
-  tags       = ["localhost:5001/order-service:0.1.0", "order-service:0.1.0"]
+  tags       = ["localhost:5001/order-service:${VERSION}", "order-service:${VERSION}"]
+  args = {
+    VERSION  = VERSION
+    REVISION = REVISION
+  }
 }
 
 target "inventory-service" {
   context = "."
   dockerfile = "./infrastructure/docker/inventory-service/Dockerfile"
-  tags       = ["localhost:5001/inventory-service:0.1.0", "inventory-service:0.1.0"]
+  tags       = ["localhost:5001/inventory-service:${VERSION}", "inventory-service:${VERSION}"]
+  args = {
+    VERSION  = VERSION
+    REVISION = REVISION
+  }
 }
@@ -0,0 +1,82 @@
+// Package healthcheck wires the standard grpc.health.v1 service and server
+// reflection into a gRPC server and keeps per-service statuses up to date.
+package healthcheck
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+)
+
+// Register adds the health service to srv with every listed service
+// NOT_SERVING until the caller reports readiness, and enables server
+// reflection when requested. The overall status ("") is SERVING while the
+// process runs, so it can back a liveness probe.
+func Register(srv *grpc.Server, enableReflection bool, services ...string) *health.Server {
+	hs := health.NewServer()
+	for _, svc := range services {
+		hs.SetServingStatus(svc, healthpb.HealthCheckResponse_NOT_SERVING)
+	}
+	healthpb.RegisterHealthServer(srv, hs)
+	if enableReflection {
+		reflection.Register(srv)
+	}
+	return hs
+}
+
+// SetServing marks the given services SERVING or NOT_SERVING.
+func SetServing(hs *health.Server, serving bool, services ...string) {
+	st := healthpb.HealthCheckResponse_NOT_SERVING
+	if serving {
+		st = healthpb.HealthCheckResponse_SERVING
+	}
+	for _, svc := range services {
+		hs.SetServingStatus(svc, st)
+	}
+}
+
+// MonitorDependency polls the health of dependency over conn every interval
+// and mirrors it onto the given local services until ctx is done.
+func MonitorDependency(
+	ctx context.Context,
+	hs *health.Server,
+	conn grpc.ClientConnInterface,
+	dependency string,
+	interval time.Duration,
+	services ...string,
+) {
+	client := healthpb.NewHealthClient(conn)
+	check := func() bool {
+		checkCtx, cancel := context.WithTimeout(ctx, interval)
+		defer cancel()
+		resp, err := client.Check(checkCtx, &healthpb.HealthCheckRequest{Service: dependency})
+		if err != nil {
+			slog.Debug("dependency health check failed", slog.String("dependency", dependency), slog.Any("error", err))
+			return false
+		}
+		return resp.GetStatus() == healthpb.HealthCheckResponse_SERVING
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	last, first := false, true
+	for {
+		serving := check()
+		if first || serving != last {
+			slog.Info("dependency health changed", slog.String("dependency", dependency), slog.Bool("serving", serving))
+			SetServing(hs, serving, services...)
+			last, first = serving, false
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,241 @@
+// Package interceptors provides gRPC server interceptors that record request
+// counts, latency, status codes and open streams and write one structured log
+// line per RPC, so every service (and every new RPC) is instrumented the same
+// way. It also provides a client interceptor applying a default deadline to
+// outgoing calls.
+package interceptors
+
+import (
+	"context"
+	"log/slog"
+	"runtime/pprof"
+	"strings"
+	"time"
+
+	"Service-sharing-environment-project/telemetry"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Attribute keys follow the OpenTelemetry RPC semantic conventions.
+const (
+	RPCServiceKey    = attribute.Key("rpc.service")
+	RPCMethodKey     = attribute.Key("rpc.method")
+	RPCStatusCodeKey = attribute.Key("rpc.grpc.status_code")
+)
+
+// Interceptors holds the instruments shared by the unary and stream interceptors.
+type Interceptors struct {
+	requests      metric.Int64Counter
+	latency       metric.Float64Histogram
+	streams       metric.Int64UpDownCounter
+	logger        *slog.Logger
+	baggageKeys   []string
+	profileLabels bool
+}
+
+// Option customises Interceptors.
+type Option func(*Interceptors)
+
+// WithLogger sets the logger used for per-RPC log lines (default slog.Default()).
+func WithLogger(logger *slog.Logger) Option {
+	return func(i *Interceptors) { i.logger = logger }
+}
+
+// WithBaggageKeys adds the listed incoming baggage entries (e.g. tenant_id)
+// to the metric attributes and log fields of every RPC.
+func WithBaggageKeys(keys ...string) Option {
+	return func(i *Interceptors) { i.baggageKeys = keys }
+}
+
+// WithProfilingLabels runs every handler under the pprof labels rpc_service
+// and rpc_method, so CPU profiles can be filtered per RPC.
+func WithProfilingLabels() Option {
+	return func(i *Interceptors) { i.profileLabels = true }
+}
+
+// New creates the <prefix>_requests_total counter, the
+// <prefix>_request_duration_seconds histogram and the <prefix>_open_streams
+// up-down counter on the given meter. Durations are recorded in the request
+// context, so sampled traces become exemplars.
+func New(meter metric.Meter, prefix string, opts ...Option) (*Interceptors, error) {
+	requests, err := meter.Int64Counter(
+		prefix+"_requests_total",
+		metric.WithDescription("Total number of handled gRPC requests"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	latency, err := meter.Float64Histogram(
+		prefix+"_request_duration_seconds",
+		metric.WithDescription("Duration of handled gRPC requests"),
+		metric.WithUnit("s"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	streams, err := meter.Int64UpDownCounter(
+		prefix+"_open_streams",
+		metric.WithDescription("Number of currently open gRPC streams"),
+		metric.WithUnit("{stream}"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	i := &Interceptors{requests: requests, latency: latency, streams: streams}
+	for _, opt := range opts {
+		opt(i)
+	}
+	if i.logger == nil {
+		i.logger = slog.Default()
+	}
+	return i, nil
+}
+
+// ServerOptions returns the interceptors as options for grpc.NewServer.
+func (i *Interceptors) ServerOptions() []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(i.Unary()),
+		grpc.ChainStreamInterceptor(i.Stream()),
+	}
+}
+
+// Unary returns a unary server interceptor.
+func (i *Interceptors) Unary() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if isInfrastructure(info.FullMethod) {
+			return handler(ctx, req)
+		}
+		start := time.Now()
+		var (
+			resp any
+			err  error
+		)
+		i.withLabels(ctx, info.FullMethod, func(ctx context.Context) {
+			resp, err = handler(ctx, req)
+		})
+		i.observe(ctx, info.FullMethod, "unary", start, err)
+		return resp, err
+	}
+}
+
+// Stream returns a stream server interceptor; the latency covers the whole
+// stream, which is counted as open until its handler returns.
+func (i *Interceptors) Stream() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if isInfrastructure(info.FullMethod) {
+			return handler(srv, ss)
+		}
+		service, method := splitMethod(info.FullMethod)
+		open := metric.WithAttributes(RPCServiceKey.String(service), RPCMethodKey.String(method))
+		i.streams.Add(ss.Context(), 1, open)
+		defer i.streams.Add(ss.Context(), -1, open)
+
+		start := time.Now()
+		var err error
+		i.withLabels(ss.Context(), info.FullMethod, func(context.Context) {
+			err = handler(srv, ss)
+		})
+		i.observe(ss.Context(), info.FullMethod, streamKind(info), start, err)
+		return err
+	}
+}
+
+// withLabels calls f under the RPC pprof labels when profiling labels are enabled.
+func (i *Interceptors) withLabels(ctx context.Context, fullMethod string, f func(context.Context)) {
+	if !i.profileLabels {
+		f(ctx)
+		return
+	}
+	service, method := splitMethod(fullMethod)
+	pprof.Do(ctx, pprof.Labels("rpc_service", service, "rpc_method", method), f)
+}
+
+func (i *Interceptors) observe(ctx context.Context, fullMethod, kind string, start time.Time, err error) {
+	elapsed := time.Since(start)
+	code := status.Code(err)
+	service, method := splitMethod(fullMethod)
+
+	baggageAttrs := telemetry.BaggageAttributes(ctx, i.baggageKeys)
+	attrs := metric.WithAttributes(append([]attribute.KeyValue{
+		RPCServiceKey.String(service),
+		RPCMethodKey.String(method),
+		RPCStatusCodeKey.Int(int(code)),
+	}, baggageAttrs...)...)
+	i.requests.Add(ctx, 1, attrs)
+	i.latency.Record(ctx, elapsed.Seconds(), attrs)
+
+	logAttrs := []slog.Attr{
+		slog.String("rpc.service", service),
+		slog.String("rpc.method", method),
+		slog.String("rpc.kind", kind),
+		slog.String("rpc.grpc.status", code.String()),
+		slog.Float64("duration_ms", float64(elapsed)/float64(time.Millisecond)),
+	}
+	for _, kv := range baggageAttrs {
+		logAttrs = append(logAttrs, slog.String(string(kv.Key), kv.Value.AsString()))
+	}
+	if err != nil {
+		logAttrs = append(logAttrs, slog.String("error", status.Convert(err).Message()))
+	}
+	i.logger.LogAttrs(ctx, levelFor(code), "rpc finished", logAttrs...)
+}
+
+// isInfrastructure reports health checks and reflection calls, which are
+// issued by probes and tooling rather than clients and would only add noise.
+func isInfrastructure(fullMethod string) bool {
+	return strings.HasPrefix(fullMethod, "/grpc.health.v1.") ||
+		strings.HasPrefix(fullMethod, "/grpc.reflection.")
+}
+
+// splitMethod turns "/pkg.Service/Method" into ("pkg.Service", "Method").
+func splitMethod(fullMethod string) (string, string) {
+	name := strings.TrimPrefix(fullMethod, "/")
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		return name[:i], name[i+1:]
+	}
+	return "unknown", name
+}
+
+func streamKind(info *grpc.StreamServerInfo) string {
+	switch {
+	case info.IsClientStream && info.IsServerStream:
+		return "bidi_stream"
+	case info.IsClientStream:
+		return "client_stream"
+	default:
+		return "server_stream"
+	}
+}
+
+// levelFor logs client-side failures as warnings and server-side ones as errors.
+func levelFor(code codes.Code) slog.Level {
+	switch code {
+	case codes.OK:
+		return slog.LevelInfo
+	case codes.Canceled, codes.InvalidArgument, codes.NotFound, codes.AlreadyExists,
+		codes.PermissionDenied, codes.FailedPrecondition, codes.OutOfRange, codes.Unauthenticated:
+		return slog.LevelWarn
+	default:
+		return slog.LevelError
+	}
+}
+
+// UnaryClientTimeout applies a default deadline to outgoing unary calls whose
+// context has none.
+func UnaryClientTimeout(timeout time.Duration) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if _, ok := ctx.Deadline(); !ok && timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
@@ -0,0 +1,60 @@
+// Package lifecycle runs a gRPC server until the process is asked to stop and
+// then drains it within a grace period.
+package lifecycle
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// SignalContext returns a context that is cancelled on SIGINT or SIGTERM.
+func SignalContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+}
+
+// Serve runs srv on lis until ctx is done or Serve fails.
+//
+// On shutdown the drain hooks run first; they should end streams that never
+// finish on their own (e.g. subscriptions) and flip health checks. The server
+// then stops accepting new RPCs and waits up to grace for in-flight ones;
+// streams still open after that are closed by Stop, so clients see Unavailable.
+func Serve(ctx context.Context, srv *grpc.Server, lis net.Listener, grace time.Duration, drain ...func()) error {
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- srv.Serve(lis) }()
+
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+	}
+
+	slog.Info("shutting down gRPC server", slog.Duration("grace_period", grace))
+	for _, fn := range drain {
+		fn()
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		srv.GracefulStop()
+		close(stopped)
+	}()
+
+	timer := time.NewTimer(grace)
+	defer timer.Stop()
+	select {
+	case <-stopped:
+		slog.Info("gRPC server drained")
+	case <-timer.C:
+		slog.Warn("grace period expired, closing remaining streams")
+		srv.Stop()
+		<-stopped
+	}
+	return <-serveErr
+}
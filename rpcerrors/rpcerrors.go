@@ -0,0 +1,76 @@
+// Package rpcerrors builds gRPC status errors with google.rpc error details,
+// so that clients (and the otelgrpc span status) can tell failure types apart.
+package rpcerrors
+
+import (
+	"fmt"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/protoadapt"
+)
+
+// NotFound reports a missing resource, e.g. NotFound("product", "P001").
+func NotFound(resourceType, name string) error {
+	return withDetails(
+		status.New(codes.NotFound, fmt.Sprintf("%s %q not found", resourceType, name)),
+		&errdetails.ResourceInfo{ResourceType: resourceType, ResourceName: name},
+	)
+}
+
+// AlreadyExists reports a conflicting resource on create.
+func AlreadyExists(resourceType, name string) error {
+	return withDetails(
+		status.New(codes.AlreadyExists, fmt.Sprintf("%s %q already exists", resourceType, name)),
+		&errdetails.ResourceInfo{ResourceType: resourceType, ResourceName: name},
+	)
+}
+
+// Field describes a single invalid request field for InvalidArgument.
+func Field(field, description string) *errdetails.BadRequest_FieldViolation {
+	return &errdetails.BadRequest_FieldViolation{Field: field, Description: description}
+}
+
+// InvalidArgument reports a malformed request with its field violations.
+func InvalidArgument(msg string, violations ...*errdetails.BadRequest_FieldViolation) error {
+	return withDetails(
+		status.New(codes.InvalidArgument, msg),
+		&errdetails.BadRequest{FieldViolations: violations},
+	)
+}
+
+// Violation describes a failed precondition, e.g. Violation("STOCK", "product/P001", "...").
+func Violation(kind, subject, description string) *errdetails.PreconditionFailure_Violation {
+	return &errdetails.PreconditionFailure_Violation{Type: kind, Subject: subject, Description: description}
+}
+
+// FailedPrecondition reports a request that is valid but cannot be applied in the current state.
+func FailedPrecondition(msg string, violations ...*errdetails.PreconditionFailure_Violation) error {
+	return withDetails(
+		status.New(codes.FailedPrecondition, msg),
+		&errdetails.PreconditionFailure{Violations: violations},
+	)
+}
+
+// Unavailable reports a temporary condition, e.g. a server that is shutting down;
+// clients may retry, typically against another replica.
+func Unavailable(msg string) error {
+	return status.Error(codes.Unavailable, msg)
+}
+
+// Internal wraps an unexpected server-side error; errors that already carry
+// a gRPC status are returned unchanged.
+func Internal(err error) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+func withDetails(st *status.Status, details ...protoadapt.MessageV1) error {
+	if withDetails, err := st.WithDetails(details...); err == nil {
+		return withDetails.Err()
+	}
+	return st.Err()
+}
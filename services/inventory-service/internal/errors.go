@@ -0,0 +1,84 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+
+	pb "Service-sharing-environment-project/proto/inventory"
+	"Service-sharing-environment-project/rpcerrors"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+)
+
+const resourceProduct = "product"
+
+// productError mapuje błąd magazynu na status gRPC (NotFound lub Internal)
+func productError(productID string, err error) error {
+	if errors.Is(err, ErrProductNotFound) {
+		return rpcerrors.NotFound(resourceProduct, productID)
+	}
+	return rpcerrors.Internal(err)
+}
+
+// requireProductID odrzuca żądania bez identyfikatora produktu
+func requireProductID(productID string) error {
+	if productID == "" {
+		return rpcerrors.InvalidArgument("product_id is required",
+			rpcerrors.Field("product_id", "must not be empty"))
+	}
+	return nil
+}
+
+// validateProduct sprawdza pola wymagane przy dodawaniu i aktualizacji produktu
+func validateProduct(p *pb.ProductInfo) error {
+	var violations []*errdetails.BadRequest_FieldViolation
+	if p.ProductId == "" {
+		violations = append(violations, rpcerrors.Field("product_id", "must not be empty"))
+	}
+	if p.AvailableQuantity < 0 {
+		violations = append(violations, rpcerrors.Field("available_quantity", "must not be negative"))
+	}
+	if len(violations) > 0 {
+		return rpcerrors.InvalidArgument("invalid product", violations...)
+	}
+	return nil
+}
+
+// validateStockBatch odrzuca puste paczki oraz pozycje bez produktu lub z ilością <= 0
+func validateStockBatch(req *pb.StockBatchRequest) error {
+	if len(req.Items) == 0 {
+		return rpcerrors.InvalidArgument("stock batch is empty",
+			rpcerrors.Field("items", "at least one item is required"))
+	}
+	var violations []*errdetails.BadRequest_FieldViolation
+	for i, item := range req.Items {
+		if item.ProductId == "" {
+			violations = append(violations,
+				rpcerrors.Field(fmt.Sprintf("items[%d].product_id", i), "must not be empty"))
+		}
+		if item.Quantity <= 0 {
+			violations = append(violations,
+				rpcerrors.Field(fmt.Sprintf("items[%d].quantity", i), "must be positive"))
+		}
+	}
+	if len(violations) > 0 {
+		return rpcerrors.InvalidArgument("invalid stock batch", violations...)
+	}
+	return nil
+}
+
+// requireSessionID odrzuca operacje na rezerwacjach bez identyfikatora sesji
+func requireSessionID(sessionID string) error {
+	if sessionID == "" {
+		return rpcerrors.InvalidArgument("session_id is required",
+			rpcerrors.Field("session_id", "must not be empty"))
+	}
+	return nil
+}
+
+// insufficientStock opisuje brak towaru jako FailedPrecondition
+func insufficientStock(productID string, available, requested int32) error {
+	return rpcerrors.FailedPrecondition("insufficient stock",
+		rpcerrors.Violation("STOCK", resourceProduct+"/"+productID,
+			fmt.Sprintf("available %d, requested %d", available, requested)))
+}
@@ -0,0 +1,204 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	pb "Service-sharing-environment-project/proto/inventory"
+	"Service-sharing-environment-project/walstore"
+
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+const (
+	snapshotFileName = "snapshot.json"
+	walFileName      = "wal.log"
+
+	walOpPut    = "put"
+	walOpAdjust = "adjust"
+	walOpBatch  = "batch"
+)
+
+// walRecord to pojedynczy wpis dziennika (jedna linia JSON)
+type walRecord struct {
+	Op        string           `json:"op"`
+	ProductID string           `json:"product_id,omitempty"`
+	Delta     int32            `json:"delta,omitempty"`
+	Deltas    map[string]int32 `json:"deltas,omitempty"`
+	Product   json.RawMessage  `json:"product,omitempty"`
+}
+
+type snapshotFile struct {
+	Products []json.RawMessage `json:"products"`
+}
+
+// FileStore to trwały ProductStore: każda zmiana trafia najpierw do
+// write‐ahead logu (fsync), a okresowy snapshot pozwala ten log obciąć.
+// Przy starcie stan odtwarzany jest ze snapshotu i dziennika (pakiet walstore).
+type FileStore struct {
+	mem *MemoryStore
+	log *walstore.Log
+}
+
+// OpenFileStore odtwarza stan z katalogu dir i uruchamia okresowe snapshoty
+func OpenFileStore(dir string, snapshotInterval time.Duration) (*FileStore, error) {
+	mem := NewMemoryStore(nil)
+	log, err := walstore.Open(walstore.Options{
+		Dir:              dir,
+		SnapshotFile:     snapshotFileName,
+		WALFile:          walFileName,
+		SnapshotInterval: snapshotInterval,
+		Component:        "FileStore",
+	}, productState{mem})
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("file store recovered",
+		slog.String("component", "FileStore"),
+		slog.String("dir", dir),
+		slog.Int("products", len(mem.products)),
+		slog.Int("wal_entries", log.Entries()),
+	)
+	return &FileStore{mem: mem, log: log}, nil
+}
+
+func (s *FileStore) Get(id string) (*pb.ProductInfo, error) {
+	return s.mem.Get(id)
+}
+
+func (s *FileStore) List() ([]*pb.ProductInfo, error) {
+	return s.mem.List()
+}
+
+func (s *FileStore) Put(p *pb.ProductInfo) error {
+	raw, err := protojson.Marshal(p)
+	if err != nil {
+		return err
+	}
+	return s.log.Update(func(appendRecord func([]byte) error) error {
+		if err := appendWALRecord(appendRecord, walRecord{Op: walOpPut, Product: raw}); err != nil {
+			return err
+		}
+		return s.mem.Put(p)
+	})
+}
+
+func (s *FileStore) Adjust(id string, delta int32) (*pb.ProductInfo, error) {
+	var p *pb.ProductInfo
+	err := s.log.Update(func(appendRecord func([]byte) error) error {
+		if _, err := s.mem.Get(id); err != nil {
+			return err
+		}
+		if err := appendWALRecord(appendRecord, walRecord{Op: walOpAdjust, ProductID: id, Delta: delta}); err != nil {
+			return err
+		}
+		var err error
+		p, err = s.mem.Adjust(id, delta)
+		return err
+	})
+	return p, err
+}
+
+// AdjustBatch zapisuje całą paczkę zmian jako jeden wpis dziennika
+func (s *FileStore) AdjustBatch(deltas map[string]int32) error {
+	return s.log.Update(func(appendRecord func([]byte) error) error {
+		for id := range deltas {
+			if _, err := s.mem.Get(id); err != nil {
+				return err
+			}
+		}
+		if err := appendWALRecord(appendRecord, walRecord{Op: walOpBatch, Deltas: deltas}); err != nil {
+			return err
+		}
+		return s.mem.AdjustBatch(deltas)
+	})
+}
+
+// Close zatrzymuje snapshoty, zapisuje końcowy snapshot i zamyka dziennik
+func (s *FileStore) Close() error {
+	return s.log.Close()
+}
+
+// Snapshot zapisuje pełny stan na dysk i obcina write‐ahead log
+func (s *FileStore) Snapshot() error {
+	return s.log.Snapshot()
+}
+
+func appendWALRecord(appendRecord func([]byte) error, rec walRecord) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return appendRecord(line)
+}
+
+// productState odtwarza i zapisuje produkty MemoryStore na potrzeby walstore
+type productState struct {
+	mem *MemoryStore
+}
+
+func (st productState) Restore(data []byte) error {
+	var snap snapshotFile
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return err
+	}
+	for _, raw := range snap.Products {
+		p := &pb.ProductInfo{}
+		if err := protojson.Unmarshal(raw, p); err != nil {
+			return fmt.Errorf("decode snapshot product: %w", err)
+		}
+		st.mem.products[p.ProductId] = p
+	}
+	return nil
+}
+
+func (st productState) Replay(line []byte) error {
+	var rec walRecord
+	if err := json.Unmarshal(line, &rec); err != nil {
+		return err
+	}
+	switch rec.Op {
+	case walOpPut:
+		p := &pb.ProductInfo{}
+		if err := protojson.Unmarshal(rec.Product, p); err != nil {
+			return err
+		}
+		st.mem.products[p.ProductId] = p
+	case walOpAdjust:
+		p, ok := st.mem.products[rec.ProductID]
+		if !ok {
+			return fmt.Errorf("adjust of unknown product %q", rec.ProductID)
+		}
+		applyDelta(p, rec.Delta)
+	case walOpBatch:
+		for id := range rec.Deltas {
+			if _, ok := st.mem.products[id]; !ok {
+				return fmt.Errorf("batch adjust of unknown product %q", id)
+			}
+		}
+		for id, delta := range rec.Deltas {
+			applyDelta(st.mem.products[id], delta)
+		}
+	default:
+		return fmt.Errorf("unknown wal op %q", rec.Op)
+	}
+	return nil
+}
+
+func (st productState) Snapshot() ([]byte, error) {
+	products, err := st.mem.List()
+	if err != nil {
+		return nil, err
+	}
+	snap := snapshotFile{Products: make([]json.RawMessage, 0, len(products))}
+	for _, p := range products {
+		raw, err := protojson.Marshal(p)
+		if err != nil {
+			return nil, err
+		}
+		snap.Products = append(snap.Products, raw)
+	}
+	return json.Marshal(snap)
+}
@@ -0,0 +1,166 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	pb "Service-sharing-environment-project/proto/inventory"
+)
+
+func openTestFileStore(t *testing.T, dir string) *FileStore {
+	t.Helper()
+	s, err := OpenFileStore(dir, time.Hour)
+	if err != nil {
+		t.Fatalf("OpenFileStore: %v", err)
+	}
+	return s
+}
+
+// crash kopiuje pliki otwartego magazynu do nowego katalogu – kopia wygląda
+// jak katalog przerwanego procesu, bez końcowego snapshotu z Close
+func crash(t *testing.T, s *FileStore, dir string) string {
+	t.Helper()
+	crashed := t.TempDir()
+	for _, name := range []string{snapshotFileName, walFileName} {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			t.Fatalf("read %s: %v", name, err)
+		}
+		if err := os.WriteFile(filepath.Join(crashed, name), data, 0o644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	return crashed
+}
+
+func putProduct(t *testing.T, s ProductStore, id string, qty int32) {
+	t.Helper()
+	if err := s.Put(&pb.ProductInfo{ProductId: id, Name: id, AvailableQuantity: qty, IsAvailable: qty > 0}); err != nil {
+		t.Fatalf("Put %s: %v", id, err)
+	}
+}
+
+func wantQuantity(t *testing.T, s ProductStore, id string, want int32) {
+	t.Helper()
+	p, err := s.Get(id)
+	if err != nil {
+		t.Fatalf("Get %s: %v", id, err)
+	}
+	if p.AvailableQuantity != want {
+		t.Errorf("%s: quantity %d, want %d", id, p.AvailableQuantity, want)
+	}
+}
+
+func TestFileStoreReplaysWALOverSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	s := openTestFileStore(t, dir)
+	putProduct(t, s, "P001", 10)
+	putProduct(t, s, "P002", 5)
+	if err := s.Snapshot(); err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+	// Zmiany po snapshocie są tylko w dzienniku
+	if _, err := s.Adjust("P001", -3); err != nil {
+		t.Fatalf("Adjust: %v", err)
+	}
+	putProduct(t, s, "P003", 7)
+	dir = crash(t, s, dir)
+
+	s = openTestFileStore(t, dir)
+	defer s.Close()
+	wantQuantity(t, s, "P001", 7)
+	wantQuantity(t, s, "P002", 5)
+	wantQuantity(t, s, "P003", 7)
+}
+
+func TestFileStoreReplaysBatchRecord(t *testing.T) {
+	dir := t.TempDir()
+	s := openTestFileStore(t, dir)
+	putProduct(t, s, "P001", 10)
+	putProduct(t, s, "P002", 5)
+	if err := s.AdjustBatch(map[string]int32{"P001": -4, "P002": 2}); err != nil {
+		t.Fatalf("AdjustBatch: %v", err)
+	}
+	// Paczka z nieznanym produktem nie trafia do dziennika ani do stanu
+	if err := s.AdjustBatch(map[string]int32{"P001": -1, "P404": 1}); err == nil {
+		t.Fatal("AdjustBatch with unknown product: want error")
+	}
+	dir = crash(t, s, dir)
+
+	s = openTestFileStore(t, dir)
+	defer s.Close()
+	wantQuantity(t, s, "P001", 6)
+	wantQuantity(t, s, "P002", 7)
+}
+
+func TestFileStoreDiscardsTruncatedWALTail(t *testing.T) {
+	dir := t.TempDir()
+	s := openTestFileStore(t, dir)
+	putProduct(t, s, "P001", 10)
+	if _, err := s.Adjust("P001", -2); err != nil {
+		t.Fatalf("Adjust: %v", err)
+	}
+	dir = crash(t, s, dir)
+
+	walPath := filepath.Join(dir, walFileName)
+	before, err := os.Stat(walPath)
+	if err != nil {
+		t.Fatalf("stat wal: %v", err)
+	}
+	// Wpis urwany w połowie zapisu
+	f, err := os.OpenFile(walPath, os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		t.Fatalf("open wal: %v", err)
+	}
+	if _, err := f.WriteString(`{"op":"adjust","product_id":"P0`); err != nil {
+		t.Fatalf("write wal: %v", err)
+	}
+	f.Close()
+
+	s = openTestFileStore(t, dir)
+	wantQuantity(t, s, "P001", 8)
+	after, err := os.Stat(walPath)
+	if err != nil {
+		t.Fatalf("stat wal: %v", err)
+	}
+	if after.Size() != before.Size() {
+		t.Errorf("wal size %d after recovery, want %d", after.Size(), before.Size())
+	}
+
+	// Nowe wpisy trafiają za ostatni poprawny wpis i przetrwają kolejny restart
+	if _, err := s.Adjust("P001", 5); err != nil {
+		t.Fatalf("Adjust: %v", err)
+	}
+	dir = crash(t, s, dir)
+	s = openTestFileStore(t, dir)
+	defer s.Close()
+	wantQuantity(t, s, "P001", 13)
+}
+
+func TestFileStoreCloseWritesSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	s := openTestFileStore(t, dir)
+	putProduct(t, s, "P001", 10)
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	info, err := os.Stat(filepath.Join(dir, walFileName))
+	if err != nil {
+		t.Fatalf("stat wal: %v", err)
+	}
+	if info.Size() != 0 {
+		t.Errorf("wal size %d after Close, want 0", info.Size())
+	}
+
+	s = openTestFileStore(t, dir)
+	defer s.Close()
+	wantQuantity(t, s, "P001", 10)
+}
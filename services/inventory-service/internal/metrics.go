@@ -0,0 +1,70 @@
+package internal
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// registerMetrics rejestruje biznesowe gauge stanu magazynu. Wartości są
+// odczytywane z magazynu i rezerwacji przy każdym zbieraniu metryk.
+func (s *InventoryServer) registerMetrics(meter metric.Meter, lowStockThreshold int32) error {
+	available, err := meter.Int64ObservableGauge(
+		"inventory_stock_available",
+		metric.WithDescription("Quantity on hand per product (reservations included)"),
+		metric.WithUnit("{item}"),
+	)
+	if err != nil {
+		return err
+	}
+	reserved, err := meter.Int64ObservableGauge(
+		"inventory_stock_reserved",
+		metric.WithDescription("Quantity held by soft reservations per product"),
+		metric.WithUnit("{item}"),
+	)
+	if err != nil {
+		return err
+	}
+	lowStock, err := meter.Int64ObservableGauge(
+		"inventory_low_stock_products",
+		metric.WithDescription("Number of active products whose free quantity is at or below the low-stock threshold"),
+		metric.WithUnit("{product}"),
+	)
+	if err != nil {
+		return err
+	}
+	subscribers, err := meter.Int64ObservableGauge(
+		"inventory_alert_subscribers",
+		metric.WithDescription("Number of active SubscribeLowStockAlerts streams"),
+		metric.WithUnit("{subscriber}"),
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = meter.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		o.ObserveInt64(subscribers, s.alertSubscribers.Load())
+
+		products, err := s.store.List()
+		if err != nil {
+			return err
+		}
+		var low int64
+		for _, p := range products {
+			held := s.reservations.Reserved(p.ProductId)
+			attrs := metric.WithAttributes(
+				attribute.String("product_id", p.ProductId),
+				attribute.String("category", p.Category),
+			)
+			o.ObserveInt64(available, int64(p.AvailableQuantity), attrs)
+			o.ObserveInt64(reserved, int64(held), attrs)
+			if !p.Discontinued && p.AvailableQuantity-held <= lowStockThreshold {
+				low++
+			}
+		}
+		o.ObserveInt64(lowStock, low)
+		return nil
+	}, available, reserved, lowStock, subscribers)
+	return err
+}
@@ -0,0 +1,106 @@
+package internal
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	pb "Service-sharing-environment-project/proto/inventory"
+
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"google.golang.org/grpc"
+)
+
+// alertStream to strumień SubscribeLowStockAlerts, który pomija wysyłane alerty
+type alertStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *alertStream) Context() context.Context     { return s.ctx }
+func (s *alertStream) Send(*pb.LowStockAlert) error { return nil }
+
+// idleOrderStream to strumień InteractiveOrderStock bez żadnej akcji koszyka
+type idleOrderStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *idleOrderStream) Context() context.Context         { return s.ctx }
+func (s *idleOrderStream) Send(*pb.OrderItemResponse) error { return nil }
+func (s *idleOrderStream) Recv() (*pb.OrderItemRequest, error) {
+	<-s.ctx.Done()
+	return nil, s.ctx.Err()
+}
+
+func TestAlertSubscribersCountsOnlyAlertStreams(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	reservations := NewReservationBook(time.Minute)
+	t.Cleanup(reservations.Close)
+	s, err := NewInventoryServer(NewMemoryStore(nil), reservations,
+		sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)).Meter("test"), 10)
+	if err != nil {
+		t.Fatalf("NewInventoryServer: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{}, 3)
+	for range 2 {
+		go func() {
+			s.InteractiveOrderStock(&idleOrderStream{ctx: ctx})
+			done <- struct{}{}
+		}()
+	}
+	go func() {
+		s.SubscribeLowStockAlerts(&pb.LowStockSubscription{Threshold: 5}, &alertStream{ctx: ctx})
+		done <- struct{}{}
+	}()
+	defer func() {
+		cancel()
+		for range 3 {
+			<-done
+		}
+	}()
+
+	// Wszystkie trzy strumienie subskrybują ten sam bus
+	deadline := time.Now().Add(time.Second)
+	for {
+		s.bus.mu.Lock()
+		n := len(s.bus.subs)
+		s.bus.mu.Unlock()
+		if n == 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d bus subscribers, want 3", n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if got := alertSubscribers(t, reader); got != 1 {
+		t.Errorf("inventory_alert_subscribers = %d, want 1", got)
+	}
+}
+
+func alertSubscribers(t *testing.T, reader sdkmetric.Reader) int64 {
+	t.Helper()
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatalf("Collect: %v", err)
+	}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			if m.Name != "inventory_alert_subscribers" {
+				continue
+			}
+			gauge, ok := m.Data.(metricdata.Gauge[int64])
+			if !ok || len(gauge.DataPoints) != 1 {
+				t.Fatalf("inventory_alert_subscribers: unexpected data %T", m.Data)
+			}
+			return gauge.DataPoints[0].Value
+		}
+	}
+	t.Fatal("inventory_alert_subscribers not collected")
+	return 0
+}
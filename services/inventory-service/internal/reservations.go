@@ -0,0 +1,220 @@
+package internal
+
+import (
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"Service-sharing-environment-project/config"
+)
+
+const DefaultReservationTTL = 15 * time.Minute
+
+type reservation struct {
+	quantity  int32
+	expiresAt time.Time
+}
+
+// ReservationBook przechowuje miękkie rezerwacje kluczowane (session_id, product_id).
+// Rezerwacja nie zmienia stanu magazynu – dopiero Commit zdejmuje towar;
+// nieodnowione rezerwacje wygasają po TTL i są usuwane w tle.
+type ReservationBook struct {
+	mu        sync.Mutex
+	ttl       time.Duration
+	sessions  map[string]map[string]*reservation
+	byProduct map[string]int32
+	onExpire  func(productIDs []string)
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewReservationBook tworzy księgę rezerwacji i uruchamia sprzątanie wygasłych wpisów
+func NewReservationBook(ttl time.Duration) *ReservationBook {
+	if ttl <= 0 {
+		ttl = DefaultReservationTTL
+	}
+	b := &ReservationBook{
+		ttl:       ttl,
+		sessions:  make(map[string]map[string]*reservation),
+		byProduct: make(map[string]int32),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	go b.sweepLoop(config.SweepInterval(ttl))
+	return b
+}
+
+// SetExpiryHandler rejestruje funkcję wołaną (poza blokadą) z produktami, których rezerwacje wygasły
+func (b *ReservationBook) SetExpiryHandler(fn func(productIDs []string)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.onExpire = fn
+}
+
+// Reserved zwraca łączną zarezerwowaną ilość produktu (wszystkie sesje)
+func (b *ReservationBook) Reserved(productID string) int32 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.byProduct[productID]
+}
+
+// Held zwraca ilość produktu zarezerwowaną przez daną sesję
+func (b *ReservationBook) Held(sessionID, productID string) int32 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if r, ok := b.sessions[sessionID][productID]; ok {
+		return r.quantity
+	}
+	return 0
+}
+
+// Set ustawia rezerwację sesji na quantity (0 usuwa wpis) i odświeża TTL całej sesji
+func (b *ReservationBook) Set(sessionID, productID string, quantity int32) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	lines := b.sessions[sessionID]
+	if lines == nil {
+		if quantity <= 0 {
+			return
+		}
+		lines = make(map[string]*reservation)
+		b.sessions[sessionID] = lines
+	}
+
+	if r, ok := lines[productID]; ok {
+		b.byProduct[productID] -= r.quantity
+		delete(lines, productID)
+	}
+	if quantity > 0 {
+		lines[productID] = &reservation{quantity: quantity}
+		b.byProduct[productID] += quantity
+	}
+	if b.byProduct[productID] <= 0 {
+		delete(b.byProduct, productID)
+	}
+	if len(lines) == 0 {
+		delete(b.sessions, sessionID)
+		return
+	}
+	b.touchLocked(lines)
+}
+
+// Touch przedłuża ważność wszystkich rezerwacji sesji
+func (b *ReservationBook) Touch(sessionID string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if lines, ok := b.sessions[sessionID]; ok {
+		b.touchLocked(lines)
+	}
+}
+
+func (b *ReservationBook) touchLocked(lines map[string]*reservation) {
+	expiresAt := time.Now().Add(b.ttl)
+	for _, r := range lines {
+		r.expiresAt = expiresAt
+	}
+}
+
+// Take usuwa i zwraca rezerwacje sesji dla podanych produktów (wszystkie, gdy lista pusta)
+func (b *ReservationBook) Take(sessionID string, productIDs []string) map[string]int32 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	lines := b.sessions[sessionID]
+	taken := make(map[string]int32)
+	if len(productIDs) == 0 {
+		for pid := range lines {
+			productIDs = append(productIDs, pid)
+		}
+	}
+	for _, pid := range productIDs {
+		r, ok := lines[pid]
+		if !ok {
+			continue
+		}
+		taken[pid] = r.quantity
+		b.byProduct[pid] -= r.quantity
+		if b.byProduct[pid] <= 0 {
+			delete(b.byProduct, pid)
+		}
+		delete(lines, pid)
+	}
+	if len(lines) == 0 {
+		delete(b.sessions, sessionID)
+	}
+	return taken
+}
+
+// Close zatrzymuje sprzątanie wygasłych rezerwacji
+func (b *ReservationBook) Close() {
+	select {
+	case <-b.stop:
+		return
+	default:
+		close(b.stop)
+	}
+	<-b.done
+}
+
+func (b *ReservationBook) sweepLoop(interval time.Duration) {
+	defer close(b.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-b.stop:
+			return
+		case now := <-ticker.C:
+			b.expire(now)
+		}
+	}
+}
+
+func (b *ReservationBook) expire(now time.Time) {
+	b.mu.Lock()
+	expired := make(map[string]int32)
+	for sessionID, lines := range b.sessions {
+		for pid, r := range lines {
+			if now.Before(r.expiresAt) {
+				continue
+			}
+			slog.Info("reservation expired",
+				slog.String("component", "Reservations"),
+				slog.String("session_id", sessionID),
+				slog.String("product_id", pid),
+				slog.Int("quantity", int(r.quantity)),
+			)
+			expired[pid] += r.quantity
+			b.byProduct[pid] -= r.quantity
+			if b.byProduct[pid] <= 0 {
+				delete(b.byProduct, pid)
+			}
+			delete(lines, pid)
+		}
+		if len(lines) == 0 {
+			delete(b.sessions, sessionID)
+		}
+	}
+	onExpire := b.onExpire
+	b.mu.Unlock()
+
+	if onExpire != nil && len(expired) > 0 {
+		onExpire(sortedProductIDs(expired))
+	}
+}
+
+// sortedProductIDs zwraca klucze mapy w stałej kolejności (do odpowiedzi i logów)
+func sortedProductIDs(lines map[string]int32) []string {
+	ids := make([]string, 0, len(lines))
+	for pid := range lines {
+		ids = append(ids, pid)
+	}
+	sort.Strings(ids)
+	return ids
+}
@@ -0,0 +1,69 @@
+package internal
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestReservationBookExpiresIdleSessions(t *testing.T) {
+	const ttl = time.Minute
+	b := NewReservationBook(ttl)
+	defer b.Close()
+
+	var expired []string
+	b.SetExpiryHandler(func(productIDs []string) { expired = append(expired, productIDs...) })
+
+	start := time.Now()
+	b.Set("A", "P001", 3)
+	b.Set("A", "P002", 1)
+	b.Set("B", "P001", 2)
+
+	b.expire(start.Add(ttl / 2))
+	if got := b.Reserved("P001"); got != 5 {
+		t.Fatalf("Reserved(P001) before TTL = %d, want 5", got)
+	}
+	if expired != nil {
+		t.Fatalf("expired before TTL: %v", expired)
+	}
+
+	// Akcja sesji B przedłuża wszystkie jej rezerwacje
+	time.Sleep(10 * time.Millisecond)
+	b.Touch("B")
+	b.expire(start.Add(ttl + 5*time.Millisecond))
+
+	if got := b.Held("A", "P001"); got != 0 {
+		t.Errorf("Held(A, P001) after TTL = %d, want 0", got)
+	}
+	if got := b.Reserved("P002"); got != 0 {
+		t.Errorf("Reserved(P002) after TTL = %d, want 0", got)
+	}
+	if got := b.Held("B", "P001"); got != 2 {
+		t.Errorf("Held(B, P001) after Touch = %d, want 2", got)
+	}
+	if want := []string{"P001", "P002"}; !slices.Equal(expired, want) {
+		t.Errorf("expired products = %v, want %v", expired, want)
+	}
+}
+
+func TestReservationBookSetReplacesQuantity(t *testing.T) {
+	b := NewReservationBook(time.Minute)
+	defer b.Close()
+
+	b.Set("A", "P001", 3)
+	b.Set("A", "P001", 5)
+	b.Set("B", "P001", 1)
+	if got := b.Reserved("P001"); got != 6 {
+		t.Fatalf("Reserved(P001) = %d, want 6", got)
+	}
+	b.Set("A", "P001", 0)
+	if got := b.Reserved("P001"); got != 1 {
+		t.Fatalf("Reserved(P001) after removal = %d, want 1", got)
+	}
+	if taken := b.Take("B", nil); taken["P001"] != 1 {
+		t.Fatalf("Take(B) = %v, want P001:1", taken)
+	}
+	if got := b.Reserved("P001"); got != 0 {
+		t.Fatalf("Reserved(P001) after Take = %d, want 0", got)
+	}
+}
@@ -0,0 +1,86 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	pb "Service-sharing-environment-project/proto/inventory"
+
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// LoadProducts wczytuje katalog startowy z pliku JSON ({"products": [...]}, pola jak w ProductInfo)
+func LoadProducts(path string) ([]*pb.ProductInfo, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read seed file: %w", err)
+	}
+	var file snapshotFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("decode seed file %s: %w", path, err)
+	}
+	products := make([]*pb.ProductInfo, 0, len(file.Products))
+	for i, raw := range file.Products {
+		p := &pb.ProductInfo{}
+		if err := protojson.Unmarshal(raw, p); err != nil {
+			return nil, fmt.Errorf("decode seed product #%d: %w", i, err)
+		}
+		if p.ProductId == "" {
+			return nil, fmt.Errorf("seed product #%d has no product_id", i)
+		}
+		products = append(products, p)
+	}
+	return products, nil
+}
+
+// DefaultProducts zwraca przykładowe dane, którymi wypełniany jest pusty magazyn
+func DefaultProducts() []*pb.ProductInfo {
+	return []*pb.ProductInfo{
+		{
+			ProductId:         "P001",
+			Name:              "Wireless Mouse",
+			Description:       "Ergonomic wireless mouse with USB receiver",
+			Category:          "Electronics",
+			Discontinued:      false,
+			AvailableQuantity: 120,
+			IsAvailable:       true,
+		},
+		{
+			ProductId:         "P002",
+			Name:              "Mechanical Keyboard",
+			Description:       "RGB backlit mechanical keyboard with blue switches",
+			Category:          "Electronics",
+			Discontinued:      false,
+			AvailableQuantity: 75,
+			IsAvailable:       true,
+		},
+		{
+			ProductId:         "P003",
+			Name:              "Water Bottle",
+			Description:       "Stainless steel water bottle, 750ml",
+			Category:          "Home & Kitchen",
+			Discontinued:      false,
+			AvailableQuantity: 200,
+			IsAvailable:       true,
+		},
+		{
+			ProductId:         "P004",
+			Name:              "Notebook",
+			Description:       "A5 size ruled notebook, 200 pages",
+			Category:          "Office Supplies",
+			Discontinued:      false,
+			AvailableQuantity: 0,
+			IsAvailable:       false,
+		},
+		{
+			ProductId:         "P005",
+			Name:              "LED Desk Lamp",
+			Description:       "Adjustable LED desk lamp with USB charging port",
+			Category:          "Home & Kitchen",
+			Discontinued:      false,
+			AvailableQuantity: 45,
+			IsAvailable:       true,
+		},
+	}
+}
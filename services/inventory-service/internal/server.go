@@ -4,15 +4,15 @@ import (
 	"context"
 	"errors"
 	"io"
-	"log"
+	"log/slog"
 	"sync"
-	"time"
+	"sync/atomic"
 
 	// Po wygenerowaniu kodu *.pb.go import powinien wskazywać dokładnie
 	// tam, gdzie powstały pliki Go z inventory.proto:
 	pb "Service-sharing-environment-project/proto/inventory"
+	"Service-sharing-environment-project/rpcerrors"
 
-	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/metric"
 )
 
@@ -20,260 +20,269 @@ import (
 type InventoryServer struct {
 	pb.UnimplementedInventoryServiceServer
 
-	mu       sync.Mutex
-	products map[string]*pb.ProductInfo
+	// mu serializuje operacje złożone (odczyt + zapis) na magazynie
+	mu           sync.Mutex
+	store        ProductStore
+	reservations *ReservationBook
+	// bus rozgłasza zmiany wolnej ilości produktów (alerty niskiego stanu)
+	bus *stockBus
+	// alertSubscribers liczy strumienie SubscribeLowStockAlerts – bus subskrybują
+	// też strumienie InteractiveOrderStock, więc jego licznik się do tego nie nadaje
+	alertSubscribers atomic.Int64
 
-	requestCounter metric.Int64Counter
-	latencyHist    metric.Float64Histogram
+	// closing jest zamykany przy wyłączaniu serwera – kończy subskrypcje alertów
+	// i strumienie InteractiveOrderStock
+	closing   chan struct{}
+	closeOnce sync.Once
+	// lowStockThreshold to próg niskiego stanu (gauge i alerty InteractiveOrderStock)
+	lowStockThreshold int32
 }
 
-// NewInventoryServer tworzy nowy serwer, inicjalizuje mapę produktów i instrumenty metryk
-func NewInventoryServer(m metric.Meter) *InventoryServer {
-	ctr, err := m.Int64Counter(
-		"inventory_requests_total",
-		metric.WithDescription("Total inventory service requests"),
-	)
-	if err != nil {
-		panic(err)
+// NewInventoryServer tworzy nowy serwer na podanym magazynie produktów i księdze rezerwacji
+// oraz rejestruje na meter gauge stanu magazynu (lowStockThreshold wyznacza produkty "low stock").
+// Metryki i logi RPC rejestrują interceptory z pakietu interceptors.
+func NewInventoryServer(
+	store ProductStore,
+	reservations *ReservationBook,
+	meter metric.Meter,
+	lowStockThreshold int32,
+) (*InventoryServer, error) {
+	s := &InventoryServer{
+		store:             store,
+		reservations:      reservations,
+		bus:               newStockBus(),
+		closing:           make(chan struct{}),
+		lowStockThreshold: lowStockThreshold,
 	}
-
-	hist, err := m.Float64Histogram(
-		"inventory_request_latency_ms",
-		metric.WithDescription("Latency of inventory service requests in ms"),
-	)
-	if err != nil {
-		panic(err)
-	}
-
-	// Przykładowe wypełnienie danymi
-	initialProducts := map[string]*pb.ProductInfo{
-		"P001": {
-			ProductId:         "P001",
-			Name:              "Wireless Mouse",
-			Description:       "Ergonomic wireless mouse with USB receiver",
-			Category:          "Electronics",
-			Discontinued:      false,
-			AvailableQuantity: 120,
-			IsAvailable:       true,
-		},
-		"P002": {
-			ProductId:         "P002",
-			Name:              "Mechanical Keyboard",
-			Description:       "RGB backlit mechanical keyboard with blue switches",
-			Category:          "Electronics",
-			Discontinued:      false,
-			AvailableQuantity: 75,
-			IsAvailable:       true,
-		},
-		"P003": {
-			ProductId:         "P003",
-			Name:              "Water Bottle",
-			Description:       "Stainless steel water bottle, 750ml",
-			Category:          "Home & Kitchen",
-			Discontinued:      false,
-			AvailableQuantity: 200,
-			IsAvailable:       true,
-		},
-		"P004": {
-			ProductId:         "P004",
-			Name:              "Notebook",
-			Description:       "A5 size ruled notebook, 200 pages",
-			Category:          "Office Supplies",
-			Discontinued:      false,
-			AvailableQuantity: 0,
-			IsAvailable:       false,
-		},
-		"P005": {
-			ProductId:         "P005",
-			Name:              "LED Desk Lamp",
-			Description:       "Adjustable LED desk lamp with USB charging port",
-			Category:          "Home & Kitchen",
-			Discontinued:      false,
-			AvailableQuantity: 45,
-			IsAvailable:       true,
-		},
-	}
-
-	return &InventoryServer{
-		products:       initialProducts,
-		requestCounter: ctr,
-		latencyHist:    hist,
+	if err := s.registerMetrics(meter, lowStockThreshold); err != nil {
+		return nil, err
 	}
+	reservations.SetExpiryHandler(s.onReservationsExpired)
+	return s, nil
 }
 
 // GetProductInfo zwraca szczegóły produktu dla podanego ProductId
 func (s *InventoryServer) GetProductInfo(ctx context.Context, req *pb.ProductId) (*pb.ProductInfo, error) {
-	log.Printf("[Inventory][GetProductInfo] called with product_id=%s", req.ProductId)
-	start := time.Now()
-	defer func() {
-		s.requestCounter.Add(ctx, 1,
-			metric.WithAttributes(attribute.String("method", "GetProductInfo")),
-		)
-		elapsedMs := float64(time.Since(start).Milliseconds())
-		s.latencyHist.Record(ctx, elapsedMs,
-			metric.WithAttributes(attribute.String("method", "GetProductInfo")),
-		)
-		log.Printf("[Inventory][GetProductInfo] latency=%.2fms", elapsedMs)
-	}()
-
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	slog.DebugContext(ctx, "request received",
+		slog.String("rpc.method", "GetProductInfo"),
+		slog.String("product_id", req.ProductId),
+	)
 
-	product, exists := s.products[req.ProductId]
-	if !exists {
-		log.Printf("[Inventory][GetProductInfo] product not found: %s", req.ProductId)
-		return nil, errors.New("product not found")
+	if err := requireProductID(req.ProductId); err != nil {
+		return nil, err
+	}
+	product, err := s.store.Get(req.ProductId)
+	if err != nil {
+		slog.WarnContext(ctx, "product lookup failed",
+			slog.String("rpc.method", "GetProductInfo"),
+			slog.String("product_id", req.ProductId),
+			slog.Any("error", err),
+		)
+		return nil, productError(req.ProductId, err)
 	}
-	log.Printf("[Inventory][GetProductInfo] found product: %s, quantity=%d", product.ProductId, product.AvailableQuantity)
-	return product, nil
+	slog.InfoContext(ctx, "product found",
+		slog.String("rpc.method", "GetProductInfo"),
+		slog.String("product_id", product.ProductId),
+		slog.Int("quantity", int(product.AvailableQuantity)),
+	)
+	return s.withReservations(product), nil
 }
 
 // AddProduct dodaje nowy produkt do mapy
 func (s *InventoryServer) AddProduct(ctx context.Context, req *pb.ProductInfo) (*pb.OperationStatus, error) {
-	log.Printf("[Inventory][AddProduct] called with product_id=%s name=%s", req.ProductId, req.Name)
-	start := time.Now()
-	defer func() {
-		s.requestCounter.Add(ctx, 1,
-			metric.WithAttributes(attribute.String("method", "AddProduct")),
-		)
-		elapsedMs := float64(time.Since(start).Milliseconds())
-		s.latencyHist.Record(ctx, elapsedMs,
-			metric.WithAttributes(attribute.String("method", "AddProduct")),
-		)
-		log.Printf("[Inventory][AddProduct] latency=%.2fms", elapsedMs)
-	}()
+	slog.DebugContext(ctx, "request received",
+		slog.String("rpc.method", "AddProduct"),
+		slog.String("product_id", req.ProductId),
+		slog.String("name", req.Name),
+	)
+
+	if err := validateProduct(req); err != nil {
+		return nil, err
+	}
 
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	if _, exists := s.products[req.ProductId]; exists {
-		log.Printf("[Inventory][AddProduct] product already exists: %s", req.ProductId)
-		return &pb.OperationStatus{Success: false, Message: "Product already exists"}, nil
+	if _, err := s.store.Get(req.ProductId); err == nil {
+		slog.WarnContext(ctx, "product already exists",
+			slog.String("rpc.method", "AddProduct"),
+			slog.String("product_id", req.ProductId),
+		)
+		return nil, rpcerrors.AlreadyExists(resourceProduct, req.ProductId)
+	}
+	if err := s.store.Put(stripReservations(req)); err != nil {
+		slog.ErrorContext(ctx, "store operation failed",
+			slog.String("rpc.method", "AddProduct"),
+			slog.Any("error", err),
+		)
+		return nil, rpcerrors.Internal(err)
 	}
-	s.products[req.ProductId] = req
-	log.Printf("[Inventory][AddProduct] product added: %s", req.ProductId)
+	s.publishLocked(req.ProductId)
+	slog.InfoContext(ctx, "product added",
+		slog.String("rpc.method", "AddProduct"),
+		slog.String("product_id", req.ProductId),
+	)
 	return &pb.OperationStatus{Success: true, Message: "Product added"}, nil
 }
 
 // UpdateProduct aktualizuje istniejący produkt
 func (s *InventoryServer) UpdateProduct(ctx context.Context, req *pb.ProductInfo) (*pb.OperationStatus, error) {
-	log.Printf("[Inventory][UpdateProduct] called with product_id=%s", req.ProductId)
-	start := time.Now()
-	defer func() {
-		s.requestCounter.Add(ctx, 1,
-			metric.WithAttributes(attribute.String("method", "UpdateProduct")),
-		)
-		elapsedMs := float64(time.Since(start).Milliseconds())
-		s.latencyHist.Record(ctx, elapsedMs,
-			metric.WithAttributes(attribute.String("method", "UpdateProduct")),
-		)
-		log.Printf("[Inventory][UpdateProduct] latency=%.2fms", elapsedMs)
-	}()
+	slog.DebugContext(ctx, "request received",
+		slog.String("rpc.method", "UpdateProduct"),
+		slog.String("product_id", req.ProductId),
+	)
+
+	if err := validateProduct(req); err != nil {
+		return nil, err
+	}
 
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	if _, exists := s.products[req.ProductId]; !exists {
-		log.Printf("[Inventory][UpdateProduct] product not found: %s", req.ProductId)
-		return &pb.OperationStatus{Success: false, Message: "Product not found"}, nil
+	if _, err := s.store.Get(req.ProductId); err != nil {
+		slog.WarnContext(ctx, "product lookup failed",
+			slog.String("rpc.method", "UpdateProduct"),
+			slog.String("product_id", req.ProductId),
+			slog.Any("error", err),
+		)
+		return nil, productError(req.ProductId, err)
+	}
+	if err := s.store.Put(stripReservations(req)); err != nil {
+		slog.ErrorContext(ctx, "store operation failed",
+			slog.String("rpc.method", "UpdateProduct"),
+			slog.Any("error", err),
+		)
+		return nil, rpcerrors.Internal(err)
 	}
-	s.products[req.ProductId] = req
-	log.Printf("[Inventory][UpdateProduct] product updated: %s", req.ProductId)
+	s.publishLocked(req.ProductId)
+	slog.InfoContext(ctx, "product updated",
+		slog.String("rpc.method", "UpdateProduct"),
+		slog.String("product_id", req.ProductId),
+	)
 	return &pb.OperationStatus{Success: true, Message: "Product updated"}, nil
 }
 
 // RemoveProduct oznacza produkt jako wycofany (discontinued)
 func (s *InventoryServer) RemoveProduct(ctx context.Context, req *pb.ProductId) (*pb.OperationStatus, error) {
-	log.Printf("[Inventory][RemoveProduct] called with product_id=%s", req.ProductId)
-	start := time.Now()
-	defer func() {
-		s.requestCounter.Add(ctx, 1,
-			metric.WithAttributes(attribute.String("method", "RemoveProduct")),
-		)
-		elapsedMs := float64(time.Since(start).Milliseconds())
-		s.latencyHist.Record(ctx, elapsedMs,
-			metric.WithAttributes(attribute.String("method", "RemoveProduct")),
-		)
-		log.Printf("[Inventory][RemoveProduct] latency=%.2fms", elapsedMs)
-	}()
+	slog.DebugContext(ctx, "request received",
+		slog.String("rpc.method", "RemoveProduct"),
+		slog.String("product_id", req.ProductId),
+	)
+
+	if err := requireProductID(req.ProductId); err != nil {
+		return nil, err
+	}
 
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	if product, exists := s.products[req.ProductId]; exists {
-		product.Discontinued = true
-		log.Printf("[Inventory][RemoveProduct] marked discontinued: %s", req.ProductId)
-		return &pb.OperationStatus{Success: true, Message: "Product discontinued"}, nil
+	product, err := s.store.Get(req.ProductId)
+	if err != nil {
+		slog.WarnContext(ctx, "product lookup failed",
+			slog.String("rpc.method", "RemoveProduct"),
+			slog.String("product_id", req.ProductId),
+			slog.Any("error", err),
+		)
+		return nil, productError(req.ProductId, err)
+	}
+	product.Discontinued = true
+	if err := s.store.Put(product); err != nil {
+		slog.ErrorContext(ctx, "store operation failed",
+			slog.String("rpc.method", "RemoveProduct"),
+			slog.Any("error", err),
+		)
+		return nil, rpcerrors.Internal(err)
 	}
-	log.Printf("[Inventory][RemoveProduct] product not found: %s", req.ProductId)
-	return &pb.OperationStatus{Success: false, Message: "Product not found"}, nil
+	s.publishLocked(req.ProductId)
+	slog.InfoContext(ctx, "product marked discontinued",
+		slog.String("rpc.method", "RemoveProduct"),
+		slog.String("product_id", req.ProductId),
+	)
+	return &pb.OperationStatus{Success: true, Message: "Product discontinued"}, nil
 }
 
 // AdjustStock modyfikuje AvailableQuantity o QuantityChange
 func (s *InventoryServer) AdjustStock(ctx context.Context, req *pb.StockAdjustment) (*pb.OperationStatus, error) {
-	log.Printf("[Inventory][AdjustStock] called with product_id=%s quantity_change=%d", req.ProductId, req.QuantityChange)
-	start := time.Now()
-	defer func() {
-		s.requestCounter.Add(ctx, 1,
-			metric.WithAttributes(attribute.String("method", "AdjustStock")),
-		)
-		elapsedMs := float64(time.Since(start).Milliseconds())
-		s.latencyHist.Record(ctx, elapsedMs,
-			metric.WithAttributes(attribute.String("method", "AdjustStock")),
-		)
-		log.Printf("[Inventory][AdjustStock] latency=%.2fms", elapsedMs)
-	}()
+	slog.DebugContext(ctx, "request received",
+		slog.String("rpc.method", "AdjustStock"),
+		slog.String("product_id", req.ProductId),
+		slog.Int("quantity_change", int(req.QuantityChange)),
+	)
+
+	if err := requireProductID(req.ProductId); err != nil {
+		return nil, err
+	}
 
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	product, exists := s.products[req.ProductId]
-	if !exists {
-		log.Printf("[Inventory][AdjustStock] product not found: %s", req.ProductId)
-		return &pb.OperationStatus{Success: false, Message: "Product not found"}, nil
+	current, err := s.store.Get(req.ProductId)
+	if err != nil {
+		slog.WarnContext(ctx, "product lookup failed",
+			slog.String("rpc.method", "AdjustStock"),
+			slog.String("product_id", req.ProductId),
+			slog.Any("error", err),
+		)
+		return nil, productError(req.ProductId, err)
 	}
-
-	product.AvailableQuantity += req.QuantityChange
-	product.IsAvailable = product.AvailableQuantity > 0
-	log.Printf(
-		"[Inventory][AdjustStock] new quantity for %s = %d",
-		req.ProductId, product.AvailableQuantity,
+	// Zmniejszenie nie może sięgnąć towaru trzymanego w rezerwacjach koszyków
+	free := s.freeQuantity(current)
+	if req.QuantityChange < 0 && free+req.QuantityChange < 0 {
+		slog.WarnContext(ctx, "insufficient stock",
+			slog.String("rpc.method", "AdjustStock"),
+			slog.String("product_id", req.ProductId),
+			slog.Int("current", int(current.AvailableQuantity)),
+			slog.Int("free", int(free)),
+			slog.Int("change", int(req.QuantityChange)),
+		)
+		return nil, insufficientStock(req.ProductId, free, -req.QuantityChange)
+	}
+	product, err := s.store.Adjust(req.ProductId, req.QuantityChange)
+	if err != nil {
+		slog.ErrorContext(ctx, "store operation failed",
+			slog.String("rpc.method", "AdjustStock"),
+			slog.Any("error", err),
+		)
+		return nil, productError(req.ProductId, err)
+	}
+	s.publishLocked(req.ProductId)
+	slog.InfoContext(ctx, "stock adjusted",
+		slog.String("rpc.method", "AdjustStock"),
+		slog.String("product_id", req.ProductId),
+		slog.Int("quantity", int(product.AvailableQuantity)),
 	)
 	return &pb.OperationStatus{Success: true, Message: "Stock adjusted"}, nil
 }
 
 // BulkStockUpdate to RPC typu client‐streaming
 func (s *InventoryServer) BulkStockUpdate(stream pb.InventoryService_BulkStockUpdateServer) error {
-	log.Printf("[Inventory][BulkStockUpdate] stream started")
-	start := time.Now()
-	defer func() {
-		s.requestCounter.Add(stream.Context(), 1,
-			metric.WithAttributes(attribute.String("method", "BulkStockUpdate")),
-		)
-		elapsedMs := float64(time.Since(start).Milliseconds())
-		s.latencyHist.Record(stream.Context(), elapsedMs,
-			metric.WithAttributes(attribute.String("method", "BulkStockUpdate")),
-		)
-		log.Printf("[Inventory][BulkStockUpdate] latency=%.2fms", elapsedMs)
-	}()
+	ctx := stream.Context()
+	slog.InfoContext(ctx, "stream started", slog.String("rpc.method", "BulkStockUpdate"))
 
 	for {
 		req, err := stream.Recv()
 		if err == io.EOF {
-			log.Printf("[Inventory][BulkStockUpdate] stream EOF")
+			slog.InfoContext(ctx, "stream closed by client", slog.String("rpc.method", "BulkStockUpdate"))
 			return stream.SendAndClose(&pb.OperationStatus{Success: true, Message: "Bulk update complete"})
 		}
 		if err != nil {
-			log.Printf("[Inventory][BulkStockUpdate] Recv error: %v", err)
+			slog.WarnContext(ctx, "receive failed",
+				slog.String("rpc.method", "BulkStockUpdate"),
+				slog.Any("error", err),
+			)
 			return err
 		}
-		log.Printf(
-			"[Inventory][BulkStockUpdate] adjusting product_id=%s quantity_change=%d",
-			req.ProductId, req.QuantityChange,
+		slog.DebugContext(ctx, "adjusting stock",
+			slog.String("rpc.method", "BulkStockUpdate"),
+			slog.String("product_id", req.ProductId),
+			slog.Int("quantity_change", int(req.QuantityChange)),
 		)
-		_, err = s.AdjustStock(context.Background(), req)
+		_, err = s.AdjustStock(ctx, req)
 		if err != nil {
-			log.Printf("[Inventory][BulkStockUpdate] AdjustStock error: %v", err)
+			slog.WarnContext(ctx, "stock adjustment failed",
+				slog.String("rpc.method", "BulkStockUpdate"),
+				slog.Any("error", err),
+			)
 			return err
 		}
 	}
@@ -281,191 +290,747 @@ func (s *InventoryServer) BulkStockUpdate(stream pb.InventoryService_BulkStockUp
 
 // GetStockLevel zwraca stan magazynu (tożsamy z GetProductInfo)
 func (s *InventoryServer) GetStockLevel(ctx context.Context, req *pb.ProductId) (*pb.ProductInfo, error) {
-	log.Printf("[Inventory][GetStockLevel] called with product_id=%s", req.ProductId)
-	start := time.Now()
-	defer func() {
-		s.requestCounter.Add(ctx, 1,
-			metric.WithAttributes(attribute.String("method", "GetStockLevel")),
-		)
-		elapsedMs := float64(time.Since(start).Milliseconds())
-		s.latencyHist.Record(ctx, elapsedMs,
-			metric.WithAttributes(attribute.String("method", "GetStockLevel")),
-		)
-		log.Printf("[Inventory][GetStockLevel] latency=%.2fms", elapsedMs)
-	}()
-
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	slog.DebugContext(ctx, "request received",
+		slog.String("rpc.method", "GetStockLevel"),
+		slog.String("product_id", req.ProductId),
+	)
 
-	product, exists := s.products[req.ProductId]
-	if !exists {
-		log.Printf("[Inventory][GetStockLevel] product not found: %s", req.ProductId)
-		return nil, errors.New("product not found")
+	if err := requireProductID(req.ProductId); err != nil {
+		return nil, err
 	}
-	log.Printf("[Inventory][GetStockLevel] found product: %s, quantity=%d", product.ProductId, product.AvailableQuantity)
-	return product, nil
+	product, err := s.store.Get(req.ProductId)
+	if err != nil {
+		slog.WarnContext(ctx, "product lookup failed",
+			slog.String("rpc.method", "GetStockLevel"),
+			slog.String("product_id", req.ProductId),
+			slog.Any("error", err),
+		)
+		return nil, productError(req.ProductId, err)
+	}
+	slog.InfoContext(ctx, "product found",
+		slog.String("rpc.method", "GetStockLevel"),
+		slog.String("product_id", product.ProductId),
+		slog.Int("quantity", int(product.AvailableQuantity)),
+	)
+	return s.withReservations(product), nil
 }
 
 // ListProducts strumieniowo zwraca wszystkie produkty (opcjonalne filtrowanie)
 func (s *InventoryServer) ListProducts(req *pb.ProductFilter, stream pb.InventoryService_ListProductsServer) error {
-	log.Printf(
-		"[Inventory][ListProducts] called with category=%q include_discontinued=%t",
-		req.Category, req.IncludeDiscontinued,
+	ctx := stream.Context()
+	slog.DebugContext(ctx, "request received",
+		slog.String("rpc.method", "ListProducts"),
+		slog.String("category", req.Category),
+		slog.Bool("include_discontinued", req.IncludeDiscontinued),
 	)
-	start := time.Now()
-	defer func() {
-		s.requestCounter.Add(stream.Context(), 1,
-			metric.WithAttributes(attribute.String("method", "ListProducts")),
-		)
-		elapsedMs := float64(time.Since(start).Milliseconds())
-		s.latencyHist.Record(stream.Context(), elapsedMs,
-			metric.WithAttributes(attribute.String("method", "ListProducts")),
-		)
-		log.Printf("[Inventory][ListProducts] latency=%.2fms", elapsedMs)
-	}()
 
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	products, err := s.store.List()
+	if err != nil {
+		slog.ErrorContext(ctx, "store operation failed",
+			slog.String("rpc.method", "ListProducts"),
+			slog.Any("error", err),
+		)
+		return err
+	}
 
-	for _, p := range s.products {
+	for _, p := range products {
 		if !req.IncludeDiscontinued && p.Discontinued {
 			continue
 		}
 		if req.Category != "" && p.Category != req.Category {
 			continue
 		}
-		log.Printf(
-			"[Inventory][ListProducts] sending product_id=%s quantity=%d",
-			p.ProductId, p.AvailableQuantity,
+		slog.DebugContext(ctx, "sending product",
+			slog.String("rpc.method", "ListProducts"),
+			slog.String("product_id", p.ProductId),
+			slog.Int("quantity", int(p.AvailableQuantity)),
 		)
-		if err := stream.Send(p); err != nil {
-			log.Printf("[Inventory][ListProducts] Send error: %v", err)
+		if err := stream.Send(s.withReservations(p)); err != nil {
+			slog.WarnContext(ctx, "send failed",
+				slog.String("rpc.method", "ListProducts"),
+				slog.Any("error", err),
+			)
 			return err
 		}
 	}
-	log.Printf("[Inventory][ListProducts] stream completed")
+	slog.InfoContext(ctx, "stream completed", slog.String("rpc.method", "ListProducts"))
 	return nil
 }
 
-// SubscribeLowStockAlerts wysyła alerty co pewien czas, gdy AvailableQuantity <= threshold
+// SubscribeLowStockAlerts wysyła alert, gdy wolna ilość obserwowanego produktu spadnie do progu
+// (lub poniżej), oraz powiadomienie o odbudowie stanu, gdy znów go przekroczy
 func (s *InventoryServer) SubscribeLowStockAlerts(req *pb.LowStockSubscription, stream pb.InventoryService_SubscribeLowStockAlertsServer) error {
-	log.Printf(
-		"[Inventory][SubscribeLowStockAlerts] called with threshold=%d product_ids=%v",
-		req.Threshold, req.ProductIds,
+	ctx := stream.Context()
+	slog.DebugContext(ctx, "request received",
+		slog.String("rpc.method", "SubscribeLowStockAlerts"),
+		slog.Int("threshold", int(req.Threshold)),
+		slog.Any("product_ids", req.ProductIds),
 	)
-	start := time.Now()
-	defer func() {
-		s.requestCounter.Add(stream.Context(), 1,
-			metric.WithAttributes(attribute.String("method", "SubscribeLowStockAlerts")),
-		)
-		elapsedMs := float64(time.Since(start).Milliseconds())
-		s.latencyHist.Record(stream.Context(), elapsedMs,
-			metric.WithAttributes(attribute.String("method", "SubscribeLowStockAlerts")),
-		)
-		log.Printf("[Inventory][SubscribeLowStockAlerts] latency=%.2fms", elapsedMs)
-	}()
 
-	threshold := req.Threshold
-	ticker := time.NewTicker(5 * time.Second)
-	defer ticker.Stop()
+	watched := make(map[string]bool, len(req.ProductIds))
+	for _, pid := range req.ProductIds {
+		watched[pid] = true
+	}
+	isWatched := func(pid string) bool { return len(watched) == 0 || watched[pid] }
+	low := make(map[string]bool)
+
+	// Subskrypcja przed odczytem stanu, żeby nie zgubić zmian w międzyczasie
+	sub, unsubscribe := s.bus.subscribe()
+	defer unsubscribe()
+	s.alertSubscribers.Add(1)
+	defer s.alertSubscribers.Add(-1)
+
+	if err := s.syncLowStock(stream, req.Threshold, isWatched, low); err != nil {
+		return err
+	}
 
 	for {
 		select {
-		case <-stream.Context().Done():
-			log.Printf("[Inventory][SubscribeLowStockAlerts] client canceled")
+		case <-ctx.Done():
+			slog.InfoContext(ctx, "subscription canceled by client",
+				slog.String("rpc.method", "SubscribeLowStockAlerts"),
+			)
 			return nil
-		case <-ticker.C:
-			s.mu.Lock()
-			for _, p := range s.products {
-				if p.AvailableQuantity <= threshold {
-					log.Printf(
-						"[Inventory][SubscribeLowStockAlerts] alert for product_id=%s quantity=%d",
-						p.ProductId, p.AvailableQuantity,
-					)
-					if err := stream.Send(&pb.LowStockAlert{
-						ProductId:       p.ProductId,
-						CurrentQuantity: p.AvailableQuantity,
-						Message:         "Low stock",
-					}); err != nil {
-						s.mu.Unlock()
-						log.Printf("[Inventory][SubscribeLowStockAlerts] Send error: %v", err)
-						return err
-					}
-				}
+		case <-s.closing:
+			slog.InfoContext(ctx, "subscription closed for shutdown",
+				slog.String("rpc.method", "SubscribeLowStockAlerts"),
+			)
+			return rpcerrors.Unavailable("inventory service is shutting down")
+		case <-sub.lagged:
+			slog.InfoContext(ctx, "subscriber lagged, resyncing",
+				slog.String("rpc.method", "SubscribeLowStockAlerts"),
+			)
+			if err := s.syncLowStock(stream, req.Threshold, isWatched, low); err != nil {
+				return err
+			}
+		case ev := <-sub.events:
+			if !isWatched(ev.ProductID) {
+				continue
+			}
+			if err := sendLowStockTransition(stream, low, ev, req.Threshold); err != nil {
+				return err
 			}
-			s.mu.Unlock()
 		}
 	}
 }
 
-// InteractiveOrderStock to RPC bidirectional streaming (recv/send)
-func (s *InventoryServer) InteractiveOrderStock(stream pb.InventoryService_InteractiveOrderStockServer) error {
-	log.Printf("[Inventory][InteractiveOrderStock] stream started")
-	start := time.Now()
-	defer func() {
-		s.requestCounter.Add(stream.Context(), 1,
-			metric.WithAttributes(attribute.String("method", "InteractiveOrderStock")),
+// syncLowStock porównuje bieżący stan obserwowanych produktów z ostatnio zgłoszonym
+func (s *InventoryServer) syncLowStock(
+	stream pb.InventoryService_SubscribeLowStockAlertsServer,
+	threshold int32,
+	isWatched func(string) bool,
+	low map[string]bool,
+) error {
+	products, err := s.store.List()
+	if err != nil {
+		slog.ErrorContext(stream.Context(), "store operation failed",
+			slog.String("rpc.method", "SubscribeLowStockAlerts"),
+			slog.Any("error", err),
 		)
-		elapsedMs := float64(time.Since(start).Milliseconds())
-		s.latencyHist.Record(stream.Context(), elapsedMs,
-			metric.WithAttributes(attribute.String("method", "InteractiveOrderStock")),
+		return err
+	}
+	for _, p := range products {
+		if !isWatched(p.ProductId) {
+			continue
+		}
+		ev := stockEventOf(p, s.freeQuantity(p))
+		if err := sendLowStockTransition(stream, low, ev, threshold); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendLowStockTransition wysyła alert tylko przy przejściu przez próg (w dół lub w górę).
+// Wycofany produkt nie jest alertowany – jego stan zapominamy, więc po przywróceniu
+// (UpdateProduct) niski stan zostanie zgłoszony od nowa
+func sendLowStockTransition(
+	stream pb.InventoryService_SubscribeLowStockAlertsServer,
+	low map[string]bool,
+	ev stockEvent,
+	threshold int32,
+) error {
+	if ev.Discontinued {
+		delete(low, ev.ProductID)
+		return nil
+	}
+	isLow := ev.Quantity <= threshold
+	if isLow == low[ev.ProductID] {
+		return nil
+	}
+	low[ev.ProductID] = isLow
+
+	alert := &pb.LowStockAlert{
+		ProductId:       ev.ProductID,
+		CurrentQuantity: ev.Quantity,
+		Message:         "Low stock",
+		Type:            pb.LowStockAlert_LOW_STOCK,
+	}
+	if !isLow {
+		alert.Message = "Stock recovered"
+		alert.Type = pb.LowStockAlert_RECOVERED
+	}
+	slog.InfoContext(stream.Context(), "low stock alert",
+		slog.String("rpc.method", "SubscribeLowStockAlerts"),
+		slog.String("type", alert.Type.String()),
+		slog.String("product_id", alert.ProductId),
+		slog.Int("quantity", int(alert.CurrentQuantity)),
+	)
+	if err := stream.Send(alert); err != nil {
+		slog.WarnContext(stream.Context(), "send failed",
+			slog.String("rpc.method", "SubscribeLowStockAlerts"),
+			slog.Any("error", err),
 		)
-		log.Printf("[Inventory][InteractiveOrderStock] latency=%.2fms", elapsedMs)
+		return err
+	}
+	return nil
+}
+
+// InteractiveOrderStock to RPC bidirectional streaming (recv/send): odpowiada na każdą
+// akcję koszyka i sam wysyła alert (low_stock_alert), gdy wolna ilość produktu
+// trzymanego przez sesję strumienia spadnie – np. przez inne zamówienia – do progu niskiego stanu
+func (s *InventoryServer) InteractiveOrderStock(stream pb.InventoryService_InteractiveOrderStockServer) error {
+	ctx := stream.Context()
+	slog.InfoContext(ctx, "stream started", slog.String("rpc.method", "InteractiveOrderStock"))
+
+	// Subskrypcja przed pierwszą rezerwacją, żeby nie zgubić spadku stanu w międzyczasie
+	sub, unsubscribe := s.bus.subscribe()
+	defer unsubscribe()
+
+	// Odbiór w osobnej gorutynie – pętla poniżej wysyła też alerty bez żądania klienta.
+	// Kanał reqs jest zamykany po EOF, więc wszystkie odebrane żądania zostaną obsłużone.
+	reqs := make(chan *pb.OrderItemRequest)
+	var recvErr error
+	go func() {
+		defer close(reqs)
+		for {
+			req, err := stream.Recv()
+			if err != nil {
+				if err != io.EOF {
+					recvErr = err
+				}
+				return
+			}
+			select {
+			case reqs <- req:
+			case <-ctx.Done():
+				return
+			}
+		}
 	}()
 
+	sessions := make(map[string]bool)
+	low := make(map[string]bool)
 	for {
-		req, err := stream.Recv()
-		if err == io.EOF {
-			log.Printf("[Inventory][InteractiveOrderStock] stream EOF")
+		select {
+		case <-ctx.Done():
+			slog.InfoContext(ctx, "stream canceled by client",
+				slog.String("rpc.method", "InteractiveOrderStock"),
+			)
 			return nil
+		case <-s.closing:
+			slog.InfoContext(ctx, "stream closed for shutdown",
+				slog.String("rpc.method", "InteractiveOrderStock"),
+			)
+			return rpcerrors.Unavailable("inventory service is shutting down")
+		case <-sub.lagged:
+			// Zgubione zdarzenia – alerty dla produktów sesji wg bieżącego stanu
+			if err := s.syncSessionLowStock(stream, sessions, low); err != nil {
+				return err
+			}
+		case ev := <-sub.events:
+			if err := s.sendSessionLowStock(stream, sessions, low, ev); err != nil {
+				return err
+			}
+		case req, ok := <-reqs:
+			if !ok {
+				if recvErr != nil {
+					slog.WarnContext(ctx, "receive failed",
+						slog.String("rpc.method", "InteractiveOrderStock"),
+						slog.Any("error", recvErr),
+					)
+					return recvErr
+				}
+				slog.InfoContext(ctx, "stream closed by client",
+					slog.String("rpc.method", "InteractiveOrderStock"),
+				)
+				return nil
+			}
+			slog.DebugContext(ctx, "received request",
+				slog.String("rpc.method", "InteractiveOrderStock"),
+				slog.String("session_id", req.SessionId),
+				slog.String("action", req.Action.String()),
+				slog.String("product_id", req.ProductId),
+				slog.Int("requested_quantity", int(req.RequestedQuantity)),
+			)
+
+			resp, err := s.applyCartAction(ctx, req)
+			if err != nil {
+				slog.WarnContext(ctx, "cart action failed",
+					slog.String("rpc.method", "InteractiveOrderStock"),
+					slog.Any("error", err),
+				)
+				return err
+			}
+			if req.SessionId != "" {
+				sessions[req.SessionId] = true
+			}
+			// Własna zmiana rezerwacji nie wywołuje alertu – klient zna już wolną ilość z odpowiedzi
+			if resp.Available {
+				low[req.ProductId] = resp.AvailableQuantity <= s.lowStockThreshold
+			}
+
+			if err := stream.Send(resp); err != nil {
+				slog.WarnContext(ctx, "send failed",
+					slog.String("rpc.method", "InteractiveOrderStock"),
+					slog.Any("error", err),
+				)
+				return err
+			}
+			slog.DebugContext(ctx, "response sent",
+				slog.String("rpc.method", "InteractiveOrderStock"),
+				slog.String("product_id", resp.ProductId),
+				slog.Bool("available", resp.Available),
+				slog.Int("remaining", int(resp.AvailableQuantity)),
+			)
 		}
-		if err != nil {
-			log.Printf("[Inventory][InteractiveOrderStock] Recv error: %v", err)
+	}
+}
+
+// sendSessionLowStock wysyła alert, gdy wolna ilość produktu trzymanego przez
+// którąś z sesji strumienia spadnie do progu niskiego stanu (raz na każdy spadek)
+func (s *InventoryServer) sendSessionLowStock(
+	stream pb.InventoryService_InteractiveOrderStockServer,
+	sessions map[string]bool,
+	low map[string]bool,
+	ev stockEvent,
+) error {
+	if !s.sessionsHold(sessions, ev.ProductID) {
+		return nil
+	}
+	isLow := ev.Quantity <= s.lowStockThreshold
+	wasLow := low[ev.ProductID]
+	low[ev.ProductID] = isLow
+	if !isLow || wasLow {
+		return nil
+	}
+
+	ctx := stream.Context()
+	slog.InfoContext(ctx, "low stock alert sent",
+		slog.String("rpc.method", "InteractiveOrderStock"),
+		slog.String("product_id", ev.ProductID),
+		slog.Int("free", int(ev.Quantity)),
+	)
+	err := stream.Send(&pb.OrderItemResponse{
+		ProductId:         ev.ProductID,
+		Available:         ev.Quantity > 0,
+		AvailableQuantity: ev.Quantity,
+		Message:           "Low stock",
+		LowStockAlert:     true,
+	})
+	if err != nil {
+		slog.WarnContext(ctx, "send failed",
+			slog.String("rpc.method", "InteractiveOrderStock"),
+			slog.Any("error", err),
+		)
+	}
+	return err
+}
+
+// syncSessionLowStock porównuje bieżący stan produktów sesji strumienia z ostatnio zgłoszonym
+func (s *InventoryServer) syncSessionLowStock(
+	stream pb.InventoryService_InteractiveOrderStockServer,
+	sessions map[string]bool,
+	low map[string]bool,
+) error {
+	products, err := s.store.List()
+	if err != nil {
+		return rpcerrors.Internal(err)
+	}
+	for _, p := range products {
+		ev := stockEvent{ProductID: p.ProductId, Quantity: s.freeQuantity(p)}
+		if err := s.sendSessionLowStock(stream, sessions, low, ev); err != nil {
 			return err
 		}
-		log.Printf(
-			"[Inventory][InteractiveOrderStock] received request product_id=%s requested_quantity=%d",
-			req.ProductId, req.RequestedQuantity,
+	}
+	return nil
+}
+
+// sessionsHold zwraca true, gdy któraś z sesji trzyma rezerwację produktu
+func (s *InventoryServer) sessionsHold(sessions map[string]bool, productID string) bool {
+	for sid := range sessions {
+		if s.reservations.Held(sid, productID) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// applyCartAction obsługuje pozycję koszyka sesji (ADD/UPDATE/REMOVE) jako miękką rezerwację;
+// stan magazynu pozostaje bez zmian do CommitReservation
+func (s *InventoryServer) applyCartAction(ctx context.Context, req *pb.OrderItemRequest) (*pb.OrderItemResponse, error) {
+	reject := func(free int32, msg string) *pb.OrderItemResponse {
+		slog.WarnContext(ctx, "cart action rejected",
+			slog.String("rpc.method", "InteractiveOrderStock"),
+			slog.String("session_id", req.SessionId),
+			slog.String("product_id", req.ProductId),
+			slog.String("action", req.Action.String()),
+			slog.String("reason", msg),
+		)
+		return &pb.OrderItemResponse{ProductId: req.ProductId, AvailableQuantity: free, Message: msg}
+	}
+
+	if req.SessionId == "" {
+		return reject(0, "Missing session_id"), nil
+	}
+	switch req.Action {
+	case pb.OrderItemRequest_ADD, pb.OrderItemRequest_UPDATE, pb.OrderItemRequest_REMOVE:
+	default:
+		return reject(0, "Unspecified action: expected ADD, UPDATE or REMOVE"), nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, err := s.store.Get(req.ProductId)
+	if errors.Is(err, ErrProductNotFound) {
+		return reject(0, "Product not found"), nil
+	}
+	if err != nil {
+		return nil, rpcerrors.Internal(err)
+	}
+
+	held := s.reservations.Held(req.SessionId, req.ProductId)
+	free := s.freeQuantity(p)
+
+	var target int32
+	var msg string
+	switch req.Action {
+	case pb.OrderItemRequest_ADD:
+		if req.RequestedQuantity <= 0 {
+			return reject(free, "Quantity must be positive"), nil
+		}
+		target, msg = held+req.RequestedQuantity, "Reserved"
+	case pb.OrderItemRequest_UPDATE:
+		if held == 0 {
+			resp := reject(free, "Item not in session; use ADD")
+			resp.NotHeld = true
+			return resp, nil
+		}
+		if req.RequestedQuantity <= 0 {
+			return reject(free, "Quantity must be positive; use REMOVE"), nil
+		}
+		target, msg = req.RequestedQuantity, "Reservation updated"
+	case pb.OrderItemRequest_REMOVE:
+		if held == 0 {
+			resp := reject(free, "Item not in session")
+			resp.NotHeld = true
+			return resp, nil
+		}
+		target, msg = 0, "Reservation released"
+	}
+
+	delta := target - held
+	if delta > free {
+		slog.WarnContext(ctx, "insufficient stock",
+			slog.String("rpc.method", "InteractiveOrderStock"),
+			slog.String("product_id", req.ProductId),
+			slog.Int("free", int(free)),
+			slog.Int("requested", int(delta)),
 		)
+		s.reservations.Touch(req.SessionId)
+		return &pb.OrderItemResponse{
+			ProductId:         req.ProductId,
+			Available:         false,
+			AvailableQuantity: free,
+			Message:           "Insufficient stock",
+		}, nil
+	}
 
-		s.mu.Lock()
-		p, ok := s.products[req.ProductId]
-		var resp pb.OrderItemResponse
+	s.reservations.Set(req.SessionId, req.ProductId, target)
+	if delta != 0 {
+		s.publishLocked(req.ProductId)
+	}
+	slog.InfoContext(ctx, "reservation changed",
+		slog.String("rpc.method", "InteractiveOrderStock"),
+		slog.String("action", req.Action.String()),
+		slog.String("session_id", req.SessionId),
+		slog.String("product_id", req.ProductId),
+		slog.Int("held", int(target)),
+		slog.Int("free", int(free-delta)),
+	)
+	return &pb.OrderItemResponse{
+		ProductId:         req.ProductId,
+		Available:         true,
+		AvailableQuantity: free - delta,
+		Message:           msg,
+	}, nil
+}
 
-		if !ok || p.AvailableQuantity < req.RequestedQuantity {
-			log.Printf(
-				"[Inventory][InteractiveOrderStock] insufficient stock for product_id=%s current=%d requested=%d",
-				req.ProductId, p.AvailableQuantity, req.RequestedQuantity,
-			)
-			resp = pb.OrderItemResponse{
-				ProductId:         req.ProductId,
-				Available:         false,
-				AvailableQuantity: p.AvailableQuantity,
-				Message:           "Insufficient stock",
+// CommitReservation zamienia rezerwacje sesji na faktyczne zdjęcie towaru z magazynu
+func (s *InventoryServer) CommitReservation(ctx context.Context, req *pb.ReservationRequest) (*pb.ReservationResult, error) {
+	slog.DebugContext(ctx, "request received",
+		slog.String("rpc.method", "CommitReservation"),
+		slog.String("session_id", req.SessionId),
+		slog.Any("product_ids", req.ProductIds),
+	)
+
+	if err := requireSessionID(req.SessionId); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	taken := s.reservations.Take(req.SessionId, req.ProductIds)
+	if len(taken) == 0 {
+		slog.InfoContext(ctx, "no reservations for session",
+			slog.String("rpc.method", "CommitReservation"),
+			slog.String("session_id", req.SessionId),
+		)
+		return nil, rpcerrors.NotFound("reservation", req.SessionId)
+	}
+	// Błąd magazynu przerywa żądanie – niezatwierdzone pozycje odzyskują rezerwacje
+	pids := sortedProductIDs(taken)
+	restore := func(pids []string) {
+		for _, pid := range pids {
+			s.reservations.Set(req.SessionId, pid, taken[pid])
+		}
+	}
+	result := &pb.ReservationResult{Success: true, Message: "Reservations committed"}
+	for i, pid := range pids {
+		qty := taken[pid]
+		line := &pb.ReservationLine{ProductId: pid, Quantity: qty}
+
+		p, err := s.store.Get(pid)
+		switch {
+		case errors.Is(err, ErrProductNotFound):
+			line.Message = "Product not found"
+		case err != nil:
+			restore(pids[i:])
+			return nil, rpcerrors.Internal(err)
+		case p.AvailableQuantity < qty:
+			// Niezatwierdzona pozycja zachowuje rezerwację – sesja może ją zmienić albo zwolnić
+			s.reservations.Set(req.SessionId, pid, qty)
+			line.Message = "Insufficient stock; reservation kept"
+		default:
+			if _, err := s.store.Adjust(pid, -qty); err != nil {
+				restore(pids[i:])
+				return nil, rpcerrors.Internal(err)
 			}
-		} else {
-			p.AvailableQuantity -= req.RequestedQuantity
-			p.IsAvailable = p.AvailableQuantity > 0
-			log.Printf(
-				"[Inventory][InteractiveOrderStock] reserved product_id=%s new_quantity=%d",
-				req.ProductId, p.AvailableQuantity,
+			line.Applied = true
+			line.Message = "Committed"
+		}
+		if !line.Applied {
+			slog.WarnContext(ctx, "reservation line not committed",
+				slog.String("rpc.method", "CommitReservation"),
+				slog.String("product_id", pid),
+				slog.Int("quantity", int(qty)),
+				slog.String("message", line.Message),
 			)
-			resp = pb.OrderItemResponse{
-				ProductId:         req.ProductId,
-				Available:         true,
-				AvailableQuantity: p.AvailableQuantity,
-				Message:           "Reserved",
+			result.Success = false
+			result.Message = "One or more reservations could not be committed"
+		}
+		result.Lines = append(result.Lines, line)
+		s.publishLocked(pid)
+	}
+	slog.InfoContext(ctx, "reservations committed",
+		slog.String("rpc.method", "CommitReservation"),
+		slog.String("session_id", req.SessionId),
+		slog.Bool("success", result.Success),
+		slog.Int("lines", len(result.Lines)),
+	)
+	return result, nil
+}
+
+// ReleaseReservation zwalnia rezerwacje sesji bez zmiany stanu magazynu
+func (s *InventoryServer) ReleaseReservation(ctx context.Context, req *pb.ReservationRequest) (*pb.ReservationResult, error) {
+	slog.DebugContext(ctx, "request received",
+		slog.String("rpc.method", "ReleaseReservation"),
+		slog.String("session_id", req.SessionId),
+		slog.Any("product_ids", req.ProductIds),
+	)
+
+	if err := requireSessionID(req.SessionId); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	taken := s.reservations.Take(req.SessionId, req.ProductIds)
+	if len(taken) == 0 {
+		slog.InfoContext(ctx, "no reservations for session",
+			slog.String("rpc.method", "ReleaseReservation"),
+			slog.String("session_id", req.SessionId),
+		)
+		return nil, rpcerrors.NotFound("reservation", req.SessionId)
+	}
+	result := &pb.ReservationResult{Success: true, Message: "Reservations released"}
+	for _, pid := range sortedProductIDs(taken) {
+		result.Lines = append(result.Lines, &pb.ReservationLine{
+			ProductId: pid,
+			Quantity:  taken[pid],
+			Applied:   true,
+			Message:   "Released",
+		})
+		s.publishLocked(pid)
+	}
+	slog.InfoContext(ctx, "reservations released",
+		slog.String("rpc.method", "ReleaseReservation"),
+		slog.String("session_id", req.SessionId),
+		slog.Int("lines", len(result.Lines)),
+	)
+	return result, nil
+}
+
+// ApplyStockBatch atomowo sprawdza i zdejmuje towar dla wielu pozycji pod jedną blokadą.
+// Rezerwacje sesji session_id są zużywane w pierwszej kolejności.
+func (s *InventoryServer) ApplyStockBatch(ctx context.Context, req *pb.StockBatchRequest) (*pb.StockBatchResponse, error) {
+	slog.DebugContext(ctx, "request received",
+		slog.String("rpc.method", "ApplyStockBatch"),
+		slog.String("session_id", req.SessionId),
+		slog.Int("items", len(req.Items)),
+		slog.Bool("all_or_nothing", req.AllOrNothing),
+	)
+
+	if err := validateStockBatch(req); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// 1) Sprawdzenie wszystkich pozycji (z uwzględnieniem powtórzeń produktu)
+	planned := make(map[string]int32)
+	results := make([]*pb.StockBatchItemResult, len(req.Items))
+	failed := 0
+	for i, item := range req.Items {
+		r := &pb.StockBatchItemResult{ProductId: item.ProductId}
+		results[i] = r
+
+		p, err := s.store.Get(item.ProductId)
+		switch {
+		case errors.Is(err, ErrProductNotFound):
+			r.Message = "Product not found"
+		case err != nil:
+			return nil, rpcerrors.Internal(err)
+		default:
+			// Własne rezerwacje sesji są dla niej dostępne
+			free := s.freeQuantity(p) + s.reservations.Held(req.SessionId, item.ProductId) - planned[item.ProductId]
+			r.AvailableQuantity = free
+			if item.Quantity > free {
+				r.Message = "Insufficient stock"
+			} else {
+				planned[item.ProductId] += item.Quantity
+				r.Applied = true
 			}
 		}
-		s.mu.Unlock()
+		if !r.Applied {
+			failed++
+		}
+	}
 
-		if err := stream.Send(&resp); err != nil {
-			log.Printf("[Inventory][InteractiveOrderStock] Send error: %v", err)
-			return err
+	if failed > 0 && req.AllOrNothing {
+		for _, r := range results {
+			if r.Applied {
+				r.Applied = false
+				r.Message = "Not applied: batch rejected"
+			}
 		}
-		log.Printf(
-			"[Inventory][InteractiveOrderStock] sent response for product_id=%s available=%v remaining=%d",
-			resp.ProductId, resp.Available, resp.AvailableQuantity,
+		slog.WarnContext(ctx, "stock batch rejected",
+			slog.String("rpc.method", "ApplyStockBatch"),
+			slog.Int("failed", failed),
+			slog.Int("items", len(req.Items)),
 		)
+		return &pb.StockBatchResponse{Success: false, Message: "Batch rejected", Results: results}, nil
 	}
+
+	// 2) Zdjęcie towaru jedną operacją magazynu i zużycie rezerwacji sesji
+	deltas := make(map[string]int32, len(planned))
+	for pid, qty := range planned {
+		deltas[pid] = -qty
+	}
+	if err := s.store.AdjustBatch(deltas); err != nil {
+		slog.ErrorContext(ctx, "store operation failed",
+			slog.String("rpc.method", "ApplyStockBatch"),
+			slog.Any("error", err),
+		)
+		return nil, rpcerrors.Internal(err)
+	}
+	for pid, qty := range planned {
+		if held := s.reservations.Held(req.SessionId, pid); held > 0 {
+			s.reservations.Set(req.SessionId, pid, max(held-qty, 0))
+		}
+		s.publishLocked(pid)
+	}
+	for _, r := range results {
+		if !r.Applied {
+			continue
+		}
+		r.Message = "Applied"
+		if p, err := s.store.Get(r.ProductId); err == nil {
+			r.AvailableQuantity = s.freeQuantity(p)
+		}
+	}
+
+	resp := &pb.StockBatchResponse{Success: failed == 0, Message: "Batch applied", Results: results}
+	if failed > 0 {
+		resp.Message = "Batch partially applied"
+	}
+	slog.InfoContext(ctx, "stock batch applied",
+		slog.String("rpc.method", "ApplyStockBatch"),
+		slog.String("session_id", req.SessionId),
+		slog.Int("applied", len(req.Items)-failed),
+		slog.Int("failed", failed),
+	)
+	return resp, nil
+}
+
+// Shutdown kończy (statusem Unavailable) subskrypcje, które same nigdy się nie kończą,
+// tak aby GracefulStop nie czekał na nie do upływu okresu karencji
+func (s *InventoryServer) Shutdown() {
+	s.closeOnce.Do(func() { close(s.closing) })
+}
+
+// publishLocked rozgłasza bieżącą wolną ilość produktu; wywoływane pod s.mu
+func (s *InventoryServer) publishLocked(productID string) {
+	p, err := s.store.Get(productID)
+	if err != nil {
+		return
+	}
+	s.bus.publish(stockEventOf(p, s.freeQuantity(p)))
+}
+
+// stockEventOf buduje zdarzenie magistrali dla produktu o wolnej ilości free
+func stockEventOf(p *pb.ProductInfo, free int32) stockEvent {
+	return stockEvent{ProductID: p.ProductId, Quantity: free, Discontinued: p.Discontinued}
+}
+
+// onReservationsExpired publikuje zmiany stanu po wygaśnięciu rezerwacji
+func (s *InventoryServer) onReservationsExpired(productIDs []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, pid := range productIDs {
+		s.publishLocked(pid)
+	}
+}
+
+// freeQuantity to stan magazynowy pomniejszony o wszystkie rezerwacje
+func (s *InventoryServer) freeQuantity(p *pb.ProductInfo) int32 {
+	return p.AvailableQuantity - s.reservations.Reserved(p.ProductId)
+}
+
+// withReservations uzupełnia odpowiedź o zarezerwowaną ilość i faktyczną dostępność
+func (s *InventoryServer) withReservations(p *pb.ProductInfo) *pb.ProductInfo {
+	p.ReservedQuantity = s.reservations.Reserved(p.ProductId)
+	p.IsAvailable = p.AvailableQuantity-p.ReservedQuantity > 0
+	return p
+}
+
+func stripReservations(p *pb.ProductInfo) *pb.ProductInfo {
+	out := cloneProduct(p)
+	out.ReservedQuantity = 0
+	return out
 }
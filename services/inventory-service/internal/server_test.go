@@ -0,0 +1,318 @@
+package internal
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	pb "Service-sharing-environment-project/proto/inventory"
+
+	"go.opentelemetry.io/otel/metric/noop"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func newTestServer(t *testing.T, products ...*pb.ProductInfo) *InventoryServer {
+	t.Helper()
+	reservations := NewReservationBook(time.Minute)
+	t.Cleanup(reservations.Close)
+	s, err := NewInventoryServer(NewMemoryStore(products), reservations, noop.NewMeterProvider().Meter("test"), 10)
+	if err != nil {
+		t.Fatalf("NewInventoryServer: %v", err)
+	}
+	return s
+}
+
+func TestAdjustStockKeepsReservedStock(t *testing.T) {
+	ctx := context.Background()
+	s := newTestServer(t, &pb.ProductInfo{ProductId: "P001", AvailableQuantity: 10, IsAvailable: true})
+	s.reservations.Set("S1", "P001", 8)
+
+	// Na stanie 10, ale wolne tylko 2
+	_, err := s.AdjustStock(ctx, &pb.StockAdjustment{ProductId: "P001", QuantityChange: -3})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("AdjustStock(-3) error = %v, want FailedPrecondition", err)
+	}
+	if _, err := s.AdjustStock(ctx, &pb.StockAdjustment{ProductId: "P001", QuantityChange: -2}); err != nil {
+		t.Fatalf("AdjustStock(-2): %v", err)
+	}
+	p, err := s.store.Get("P001")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if p.AvailableQuantity != 8 || s.freeQuantity(p) != 0 {
+		t.Fatalf("on hand %d, free %d; want 8 and 0", p.AvailableQuantity, s.freeQuantity(p))
+	}
+	// Dostawa jest przyjmowana niezależnie od rezerwacji
+	if _, err := s.AdjustStock(ctx, &pb.StockAdjustment{ProductId: "P001", QuantityChange: 5}); err != nil {
+		t.Fatalf("AdjustStock(+5): %v", err)
+	}
+}
+
+func product(id string, qty int32) *pb.ProductInfo {
+	return &pb.ProductInfo{ProductId: id, Name: id, AvailableQuantity: qty, IsAvailable: qty > 0}
+}
+
+func TestApplyStockBatchAllOrNothingRollsBack(t *testing.T) {
+	s := newTestServer(t, product("P001", 10), product("P002", 1))
+	resp, err := s.ApplyStockBatch(context.Background(), &pb.StockBatchRequest{
+		AllOrNothing: true,
+		Items: []*pb.StockBatchItem{
+			{ProductId: "P001", Quantity: 3},
+			{ProductId: "P002", Quantity: 5},
+		},
+	})
+	if err != nil {
+		t.Fatalf("ApplyStockBatch: %v", err)
+	}
+	if resp.Success {
+		t.Error("batch with an unavailable line succeeded")
+	}
+	for _, r := range resp.Results {
+		if r.Applied {
+			t.Errorf("%s applied in a rejected batch", r.ProductId)
+		}
+	}
+	if got := resp.Results[1].Message; got != "Insufficient stock" {
+		t.Errorf("P002 message %q, want Insufficient stock", got)
+	}
+	wantQuantity(t, s.store, "P001", 10)
+	wantQuantity(t, s.store, "P002", 1)
+}
+
+func TestApplyStockBatchCountsRepeatedProduct(t *testing.T) {
+	item := &pb.StockBatchItem{ProductId: "P001", Quantity: 3}
+
+	// Każda linia osobno się mieści, obie razem już nie
+	s := newTestServer(t, product("P001", 5))
+	resp, err := s.ApplyStockBatch(context.Background(), &pb.StockBatchRequest{AllOrNothing: true, Items: []*pb.StockBatchItem{item, item}})
+	if err != nil {
+		t.Fatalf("ApplyStockBatch: %v", err)
+	}
+	if resp.Success {
+		t.Error("all-or-nothing batch beyond stock succeeded")
+	}
+	wantQuantity(t, s.store, "P001", 5)
+
+	resp, err = s.ApplyStockBatch(context.Background(), &pb.StockBatchRequest{Items: []*pb.StockBatchItem{item, item}})
+	if err != nil {
+		t.Fatalf("ApplyStockBatch: %v", err)
+	}
+	if resp.Success || !resp.Results[0].Applied || resp.Results[1].Applied {
+		t.Errorf("partial batch: success %v, applied %v/%v; want false, true/false",
+			resp.Success, resp.Results[0].Applied, resp.Results[1].Applied)
+	}
+	if got := resp.Results[1].AvailableQuantity; got != 2 {
+		t.Errorf("second line sees %d free, want 2", got)
+	}
+	wantQuantity(t, s.store, "P001", 2)
+}
+
+func TestApplyStockBatchConsumesSessionHolds(t *testing.T) {
+	s := newTestServer(t, product("P001", 10))
+	s.reservations.Set("S1", "P001", 4)
+	s.reservations.Set("S2", "P001", 6)
+	ctx := context.Background()
+
+	// Cały wolny towar jest zarezerwowany – sesja bez rezerwacji nic nie dostanie
+	resp, err := s.ApplyStockBatch(ctx, &pb.StockBatchRequest{SessionId: "S3", Items: []*pb.StockBatchItem{{ProductId: "P001", Quantity: 1}}})
+	if err != nil {
+		t.Fatalf("ApplyStockBatch S3: %v", err)
+	}
+	if resp.Success {
+		t.Error("S3 deducted stock reserved by other sessions")
+	}
+
+	resp, err = s.ApplyStockBatch(ctx, &pb.StockBatchRequest{SessionId: "S1", Items: []*pb.StockBatchItem{{ProductId: "P001", Quantity: 4}}})
+	if err != nil {
+		t.Fatalf("ApplyStockBatch S1: %v", err)
+	}
+	if !resp.Success {
+		t.Fatalf("S1 could not use its own holds: %s", resp.Results[0].Message)
+	}
+	wantQuantity(t, s.store, "P001", 6)
+	if held := s.reservations.Held("S1", "P001"); held != 0 {
+		t.Errorf("S1 still holds %d after the batch, want 0", held)
+	}
+	if held := s.reservations.Held("S2", "P001"); held != 6 {
+		t.Errorf("S2 holds %d, want 6", held)
+	}
+}
+
+func TestCommitReservationKeepsHoldOfFailedLine(t *testing.T) {
+	s := newTestServer(t, product("P001", 5), product("P002", 5))
+	s.reservations.Set("S1", "P001", 3)
+	s.reservations.Set("S1", "P002", 2)
+	// Stan spadł poniżej rezerwacji (np. korekta inwentaryzacyjna)
+	if _, err := s.store.Adjust("P001", -4); err != nil {
+		t.Fatalf("Adjust: %v", err)
+	}
+
+	resp, err := s.CommitReservation(context.Background(), &pb.ReservationRequest{SessionId: "S1"})
+	if err != nil {
+		t.Fatalf("CommitReservation: %v", err)
+	}
+	if resp.Success {
+		t.Error("commit beyond stock succeeded")
+	}
+	for _, line := range resp.Lines {
+		if line.Applied != (line.ProductId == "P002") {
+			t.Errorf("%s applied = %v (%s)", line.ProductId, line.Applied, line.Message)
+		}
+	}
+	if held := s.reservations.Held("S1", "P001"); held != 3 {
+		t.Errorf("S1 holds %d of P001 after the failed line, want 3", held)
+	}
+	if held := s.reservations.Held("S1", "P002"); held != 0 {
+		t.Errorf("S1 holds %d of P002 after commit, want 0", held)
+	}
+	wantQuantity(t, s.store, "P001", 1)
+	wantQuantity(t, s.store, "P002", 3)
+}
+
+// recordingAlertStream przekazuje wysłane alerty do kanału alerts
+type recordingAlertStream struct {
+	grpc.ServerStream
+	ctx    context.Context
+	alerts chan *pb.LowStockAlert
+}
+
+func (s *recordingAlertStream) Context() context.Context { return s.ctx }
+func (s *recordingAlertStream) Send(alert *pb.LowStockAlert) error {
+	s.alerts <- alert
+	return nil
+}
+
+// subscribeAlerts uruchamia SubscribeLowStockAlerts i czeka, aż subskrypcja trafi na bus
+func subscribeAlerts(t *testing.T, s *InventoryServer, req *pb.LowStockSubscription) <-chan *pb.LowStockAlert {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	stream := &recordingAlertStream{ctx: ctx, alerts: make(chan *pb.LowStockAlert, 16)}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.SubscribeLowStockAlerts(req, stream)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		s.bus.mu.Lock()
+		n := len(s.bus.subs)
+		s.bus.mu.Unlock()
+		if n == 1 {
+			return stream.alerts
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("subscription never reached the stock bus")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func wantAlert(t *testing.T, alerts <-chan *pb.LowStockAlert, id string, typ pb.LowStockAlert_AlertType, qty int32) {
+	t.Helper()
+	select {
+	case a := <-alerts:
+		if a.ProductId != id || a.Type != typ || a.CurrentQuantity != qty {
+			t.Fatalf("alert %s %s %d, want %s %s %d", a.ProductId, a.Type, a.CurrentQuantity, id, typ, qty)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("no alert, want %s %s %d", id, typ, qty)
+	}
+}
+
+func TestLowStockAlertsFireOnTransitions(t *testing.T) {
+	ctx := context.Background()
+	s := newTestServer(t, product("P001", 10), product("P002", 10))
+	alerts := subscribeAlerts(t, s, &pb.LowStockSubscription{Threshold: 5, ProductIds: []string{"P001"}})
+
+	adjust := func(id string, change int32) {
+		t.Helper()
+		if _, err := s.AdjustStock(ctx, &pb.StockAdjustment{ProductId: id, QuantityChange: change}); err != nil {
+			t.Fatalf("AdjustStock(%s, %d): %v", id, change, err)
+		}
+	}
+	// Zdarzenia przychodzą po kolei, więc pominięte zmiany wychodzą przy następnym alercie
+	adjust("P002", -8) // poza product_ids
+	adjust("P001", -6)
+	wantAlert(t, alerts, "P001", pb.LowStockAlert_LOW_STOCK, 4)
+	adjust("P001", -1) // nadal poniżej progu
+	adjust("P001", 5)
+	wantAlert(t, alerts, "P001", pb.LowStockAlert_RECOVERED, 8)
+
+	// Rezerwacje też zmieniają wolną ilość
+	s.mu.Lock()
+	s.reservations.Set("S1", "P001", 4)
+	s.publishLocked("P001")
+	s.mu.Unlock()
+	wantAlert(t, alerts, "P001", pb.LowStockAlert_LOW_STOCK, 4)
+}
+
+func TestLowStockAlertsForgetRemovedProduct(t *testing.T) {
+	ctx := context.Background()
+	s := newTestServer(t, product("P001", 2))
+	alerts := subscribeAlerts(t, s, &pb.LowStockSubscription{Threshold: 5})
+	wantAlert(t, alerts, "P001", pb.LowStockAlert_LOW_STOCK, 2)
+
+	if _, err := s.RemoveProduct(ctx, &pb.ProductId{ProductId: "P001"}); err != nil {
+		t.Fatalf("RemoveProduct: %v", err)
+	}
+	// Przywrócony produkt jest alertowany od nowa
+	if _, err := s.UpdateProduct(ctx, product("P001", 2)); err != nil {
+		t.Fatalf("UpdateProduct: %v", err)
+	}
+	wantAlert(t, alerts, "P001", pb.LowStockAlert_LOW_STOCK, 2)
+}
+
+func TestInteractiveCartActions(t *testing.T) {
+	const (
+		add    = pb.OrderItemRequest_ADD
+		update = pb.OrderItemRequest_UPDATE
+		remove = pb.OrderItemRequest_REMOVE
+	)
+	s := newTestServer(t, product("P001", 10))
+	// Każdy krok widzi rezerwacje zostawione przez poprzednie
+	steps := []struct {
+		session   string
+		action    pb.OrderItemRequest_ActionType
+		qty       int32
+		available bool
+		notHeld   bool
+		free      int32
+		message   string
+		held      int32
+	}{
+		{"S1", add, 3, true, false, 7, "Reserved", 3},
+		{"S1", add, 2, true, false, 5, "Reserved", 5},
+		{"S1", add, 0, false, false, 5, "Quantity must be positive", 5},
+		{"S1", update, 8, true, false, 2, "Reservation updated", 8},
+		{"S1", update, 11, false, false, 2, "Insufficient stock", 8},
+		{"S1", update, 0, false, false, 2, "Quantity must be positive; use REMOVE", 8},
+		{"S2", update, 1, false, true, 2, "Item not in session; use ADD", 0},
+		{"S2", remove, 0, false, true, 2, "Item not in session", 0},
+		{"S1", remove, 0, true, false, 10, "Reservation released", 0},
+		{"S1", update, 4, false, true, 10, "Item not in session; use ADD", 0},
+		{"S1", pb.OrderItemRequest_ACTION_TYPE_UNSPECIFIED, 4, false, false, 0, "Unspecified action: expected ADD, UPDATE or REMOVE", 0},
+	}
+	for i, st := range steps {
+		resp, err := s.applyCartAction(context.Background(), &pb.OrderItemRequest{
+			SessionId: st.session, ProductId: "P001", RequestedQuantity: st.qty, Action: st.action,
+		})
+		if err != nil {
+			t.Fatalf("step %d (%s %s %d): %v", i, st.session, st.action, st.qty, err)
+		}
+		if resp.Available != st.available || resp.NotHeld != st.notHeld || resp.AvailableQuantity != st.free || resp.Message != st.message {
+			t.Errorf("step %d (%s %s %d): available %v, not held %v, free %d, %q; want %v, %v, %d, %q",
+				i, st.session, st.action, st.qty, resp.Available, resp.NotHeld, resp.AvailableQuantity, resp.Message,
+				st.available, st.notHeld, st.free, st.message)
+		}
+		if held := s.reservations.Held(st.session, "P001"); held != st.held {
+			t.Errorf("step %d (%s %s %d): %s holds %d, want %d", i, st.session, st.action, st.qty, st.session, held, st.held)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package internal
+
+import (
+	"sync"
+)
+
+const subscriberBuffer = 64
+
+// stockEvent informuje o nowej wolnej ilości produktu (stan minus rezerwacje)
+type stockEvent struct {
+	ProductID string
+	Quantity  int32
+	// Discontinued – produkt wycofany (RemoveProduct) nie jest już alertowany
+	Discontinued bool
+}
+
+// stockSubscription to kanał zdarzeń jednego subskrybenta; lagged sygnalizuje,
+// że część zdarzeń przepadła i subskrybent powinien odczytać stan od nowa
+type stockSubscription struct {
+	events chan stockEvent
+	lagged chan struct{}
+}
+
+// stockBus to wewnętrzna magistrala zmian stanu magazynu.
+// Publikacja nigdy nie blokuje – wolny subskrybent dostaje sygnał lagged.
+type stockBus struct {
+	mu     sync.Mutex
+	nextID int
+	subs   map[int]*stockSubscription
+}
+
+func newStockBus() *stockBus {
+	return &stockBus{subs: make(map[int]*stockSubscription)}
+}
+
+// subscribe rejestruje subskrybenta; zwrócona funkcja go wyrejestrowuje
+func (b *stockBus) subscribe() (*stockSubscription, func()) {
+	sub := &stockSubscription{
+		events: make(chan stockEvent, subscriberBuffer),
+		lagged: make(chan struct{}, 1),
+	}
+
+	b.mu.Lock()
+	id := b.nextID
+	b.nextID++
+	b.subs[id] = sub
+	b.mu.Unlock()
+
+	return sub, func() {
+		b.mu.Lock()
+		delete(b.subs, id)
+		b.mu.Unlock()
+	}
+}
+
+func (b *stockBus) publish(ev stockEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, sub := range b.subs {
+		select {
+		case sub.events <- ev:
+		default:
+			select {
+			case sub.lagged <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
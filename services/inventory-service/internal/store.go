@@ -0,0 +1,159 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	pb "Service-sharing-environment-project/proto/inventory"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// ErrProductNotFound zwracany przez ProductStore, gdy produkt nie istnieje
+var ErrProductNotFound = errors.New("product not found")
+
+const (
+	StoreKindMemory = "memory"
+	StoreKindFile   = "file"
+)
+
+// ProductStore abstrahuje miejsce przechowywania produktów.
+// Pojedyncze operacje są atomowe; sekwencje typu sprawdź‐i‐zmień
+// serializuje InventoryServer własnym mutexem.
+// Zwracane produkty są kopiami – modyfikacja wymaga wywołania Put.
+type ProductStore interface {
+	Get(id string) (*pb.ProductInfo, error)
+	Put(p *pb.ProductInfo) error
+	List() ([]*pb.ProductInfo, error)
+	Adjust(id string, delta int32) (*pb.ProductInfo, error)
+	// AdjustBatch stosuje wszystkie zmiany albo żadnej (np. gdy brakuje produktu)
+	AdjustBatch(deltas map[string]int32) error
+	Close() error
+}
+
+// StoreConfig opisuje, który ProductStore ma zostać utworzony
+type StoreConfig struct {
+	Kind             string
+	DataDir          string
+	SnapshotInterval time.Duration
+}
+
+// OpenStore tworzy ProductStore wg konfiguracji; pusty magazyn wypełnia danymi seed
+func OpenStore(cfg StoreConfig, seed []*pb.ProductInfo) (ProductStore, error) {
+	switch cfg.Kind {
+	case "", StoreKindMemory:
+		return NewMemoryStore(seed), nil
+	case StoreKindFile:
+		fs, err := OpenFileStore(cfg.DataDir, cfg.SnapshotInterval)
+		if err != nil {
+			return nil, err
+		}
+		if err := seedIfEmpty(fs, seed); err != nil {
+			fs.Close()
+			return nil, err
+		}
+		return fs, nil
+	default:
+		return nil, fmt.Errorf("unknown store kind %q", cfg.Kind)
+	}
+}
+
+func seedIfEmpty(store ProductStore, seed []*pb.ProductInfo) error {
+	existing, err := store.List()
+	if err != nil || len(existing) > 0 {
+		return err
+	}
+	for _, p := range seed {
+		if err := store.Put(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MemoryStore to ProductStore trzymający produkty wyłącznie w pamięci
+type MemoryStore struct {
+	mu       sync.RWMutex
+	products map[string]*pb.ProductInfo
+}
+
+// NewMemoryStore tworzy magazyn w pamięci wypełniony kopiami produktów seed
+func NewMemoryStore(seed []*pb.ProductInfo) *MemoryStore {
+	s := &MemoryStore{products: make(map[string]*pb.ProductInfo, len(seed))}
+	for _, p := range seed {
+		s.products[p.ProductId] = cloneProduct(p)
+	}
+	return s
+}
+
+func (s *MemoryStore) Get(id string) (*pb.ProductInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	p, ok := s.products[id]
+	if !ok {
+		return nil, ErrProductNotFound
+	}
+	return cloneProduct(p), nil
+}
+
+func (s *MemoryStore) Put(p *pb.ProductInfo) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.products[p.ProductId] = cloneProduct(p)
+	return nil
+}
+
+func (s *MemoryStore) List() ([]*pb.ProductInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	out := make([]*pb.ProductInfo, 0, len(s.products))
+	for _, p := range s.products {
+		out = append(out, cloneProduct(p))
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ProductId < out[j].ProductId })
+	return out, nil
+}
+
+func (s *MemoryStore) Adjust(id string, delta int32) (*pb.ProductInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.products[id]
+	if !ok {
+		return nil, ErrProductNotFound
+	}
+	applyDelta(p, delta)
+	return cloneProduct(p), nil
+}
+
+func (s *MemoryStore) AdjustBatch(deltas map[string]int32) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id := range deltas {
+		if _, ok := s.products[id]; !ok {
+			return ErrProductNotFound
+		}
+	}
+	for id, delta := range deltas {
+		applyDelta(s.products[id], delta)
+	}
+	return nil
+}
+
+func (s *MemoryStore) Close() error { return nil }
+
+func applyDelta(p *pb.ProductInfo, delta int32) {
+	p.AvailableQuantity += delta
+	p.IsAvailable = p.AvailableQuantity > 0
+}
+
+func cloneProduct(p *pb.ProductInfo) *pb.ProductInfo {
+	return proto.Clone(p).(*pb.ProductInfo)
+}
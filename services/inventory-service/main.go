@@ -2,55 +2,181 @@ package main
 
 import (
 	"context"
-	"log"
+	"log/slog"
 	"net"
+	"os"
+	"time"
 
+	"Service-sharing-environment-project/config"
+	"Service-sharing-environment-project/healthcheck"
+	"Service-sharing-environment-project/interceptors"
+	"Service-sharing-environment-project/lifecycle"
 	invpb "Service-sharing-environment-project/proto/inventory"
 	internal "Service-sharing-environment-project/services/inventory-service/internal"
 	telemetry "Service-sharing-environment-project/telemetry"
 
 	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc/filters"
 	"google.golang.org/grpc"
 )
 
-const port = ":50051"
+const (
+	defaultListenAddress = ":50051"
+	// telemetryFlushTimeout ogranicza czas wysyłki ostatnich spanów, metryk i logów przy wyjściu
+	telemetryFlushTimeout = 5 * time.Second
+)
+
+// fatal loguje błąd startu i kończy proces kodem 1 (defery nie są wykonywane)
+func fatal(msg string, err error) {
+	slog.Error(msg, slog.Any("error", err))
+	os.Exit(1)
+}
+
+// storeConfig mapuje sekcję store konfiguracji na internal.StoreConfig
+func storeConfig(cfg config.Store) internal.StoreConfig {
+	return internal.StoreConfig{
+		Kind:             cfg.Kind,
+		DataDir:          cfg.DataDir,
+		SnapshotInterval: cfg.SnapshotInterval,
+	}
+}
+
+// seedProducts zwraca katalog startowy z pliku seed albo wbudowane dane przykładowe
+func seedProducts(path string) ([]*invpb.ProductInfo, error) {
+	if path == "" {
+		return internal.DefaultProducts(), nil
+	}
+	return internal.LoadProducts(path)
+}
 
 func main() {
-    ctx := context.Background()
-
-    // ── Tracing setup ─────────────────────────────────────────────────────────
-    tp, err := telemetry.InitTracer(ctx, "inventory-service")
-    if err != nil {
-        log.Fatalf("[Inventory] tracer init error: %v", err)
-    }
-    defer func() {
-        if err := tp.Shutdown(ctx); err != nil {
-            log.Printf("[Inventory] error shutting down tracer: %v", err)
-        }
-    }()
-
-    // ── Metrics setup (OTLP/gRPC) ──────────────────────────────────────────────
-    mp, err := telemetry.InitMetrics(ctx, "inventory-service")
-    if err != nil {
-        log.Fatalf("[Inventory] metrics init error: %v", err)
-    }
-
-    // ── Start gRPC server ──────────────────────────────────────────────────────
-    lis, err := net.Listen("tcp", port)
-    if err != nil {
-        log.Fatalf("[Inventory] failed to listen: %v", err)
-    }
-
-    grpcServer := grpc.NewServer(
-        //Rejestrujemy StatsHandler, żeby OTel/Instruments automatycznie łapało spany i metryki
-        grpc.StatsHandler(otelgrpc.NewServerHandler()),
-    )
-
-    invSrv := internal.NewInventoryServer(mp.Meter("inventory-service"))
-    invpb.RegisterInventoryServiceServer(grpcServer, invSrv)
-
-    log.Printf("[Inventory] Starting gRPC server, listening on %s", port)
-    if err := grpcServer.Serve(lis); err != nil {
-        log.Fatalf("[Inventory] failed to serve gRPC: %v", err)
-    }
+	// ctx jest anulowany po SIGINT/SIGTERM – wtedy serwer przechodzi w tryb wygaszania
+	ctx, stop := lifecycle.SignalContext()
+	defer stop()
+
+	// ── Configuration ─────────────────────────────────────────────────────────
+	cfg, err := config.Load(config.Defaults("inventory-service", defaultListenAddress), os.Args[1:])
+	if err != nil {
+		fatal("invalid configuration", err)
+	}
+
+	// ── Logging setup (JSON na stdout + OTLP) ─────────────────────────────────
+	logger, lp, err := telemetry.InitLogger(ctx, cfg.Telemetry)
+	if err != nil {
+		fatal("logger init failed", err)
+	}
+	defer func() {
+		flushCtx, cancel := context.WithTimeout(context.Background(), telemetryFlushTimeout)
+		defer cancel()
+		if err := lp.Shutdown(flushCtx); err != nil {
+			slog.Error("logger provider shutdown failed", slog.Any("error", err))
+		}
+	}()
+
+	// ── Tracing setup ─────────────────────────────────────────────────────────
+	tp, err := telemetry.InitTracer(ctx, cfg.Telemetry)
+	if err != nil {
+		fatal("tracer init failed", err)
+	}
+	defer func() {
+		flushCtx, cancel := context.WithTimeout(context.Background(), telemetryFlushTimeout)
+		defer cancel()
+		if err := tp.Shutdown(flushCtx); err != nil {
+			slog.Error("tracer provider shutdown failed", slog.Any("error", err))
+		}
+	}()
+
+	// ── Metrics setup (OTLP/gRPC) ──────────────────────────────────────────────
+	mp, err := telemetry.InitMetrics(ctx, cfg.Telemetry)
+	if err != nil {
+		fatal("metrics init failed", err)
+	}
+	defer func() {
+		flushCtx, cancel := context.WithTimeout(context.Background(), telemetryFlushTimeout)
+		defer cancel()
+		if err := mp.Shutdown(flushCtx); err != nil {
+			slog.Error("meter provider shutdown failed", slog.Any("error", err))
+		}
+	}()
+
+	// ── Profiling (pprof / Pyroscope) ─────────────────────────────────────────
+	profiler, err := telemetry.InitProfiling(cfg.Telemetry)
+	if err != nil {
+		fatal("profiling init failed", err)
+	}
+	defer func() {
+		flushCtx, cancel := context.WithTimeout(context.Background(), telemetryFlushTimeout)
+		defer cancel()
+		if err := profiler.Shutdown(flushCtx); err != nil {
+			slog.Error("profiler shutdown failed", slog.Any("error", err))
+		}
+	}()
+
+	// ── Product store ──────────────────────────────────────────────────────────
+	seed, err := seedProducts(cfg.Store.SeedFile)
+	if err != nil {
+		fatal("loading seed data failed", err)
+	}
+	storeCfg := storeConfig(cfg.Store)
+	store, err := internal.OpenStore(storeCfg, seed)
+	if err != nil {
+		fatal("store init failed", err)
+	}
+	defer func() {
+		if err := store.Close(); err != nil {
+			slog.Error("closing store failed", slog.Any("error", err))
+		}
+	}()
+	slog.Info("product store opened", slog.String("kind", storeCfg.Kind))
+
+	// ── Soft reservations ──────────────────────────────────────────────────────
+	reservations := internal.NewReservationBook(cfg.Reservations.TTL)
+	defer reservations.Close()
+	slog.Info("reservations enabled", slog.Duration("ttl", cfg.Reservations.TTL))
+
+	// ── Start gRPC server ──────────────────────────────────────────────────────
+	lis, err := net.Listen("tcp", cfg.ListenAddress)
+	if err != nil {
+		fatal("listen failed", err)
+	}
+
+	// Wspólne interceptory: licznik żądań, histogram opóźnień i log każdego RPC
+	interceptorOpts := []interceptors.Option{
+		interceptors.WithLogger(logger),
+		interceptors.WithBaggageKeys(cfg.Telemetry.MetricKeys()...),
+	}
+	if cfg.Telemetry.Profiling.Enabled() {
+		// Etykiety pprof rpc_service/rpc_method pozwalają filtrować profile per RPC
+		interceptorOpts = append(interceptorOpts, interceptors.WithProfilingLabels())
+	}
+	rpcInterceptors, err := interceptors.New(mp.Meter("inventory-service"), "inventory", interceptorOpts...)
+	if err != nil {
+		fatal("interceptors init failed", err)
+	}
+
+	grpcServer := grpc.NewServer(append(
+		rpcInterceptors.ServerOptions(),
+		//Rejestrujemy StatsHandler, żeby OTel/Instruments automatycznie łapało spany i metryki
+		// (bez sond health checków)
+		grpc.StatsHandler(otelgrpc.NewServerHandler(otelgrpc.WithFilter(filters.Not(filters.HealthCheck())))),
+	)...)
+
+	invSrv, err := internal.NewInventoryServer(store, reservations, mp.Meter("inventory-service"), int32(cfg.Store.LowStockThreshold))
+	if err != nil {
+		fatal("inventory server init failed", err)
+	}
+	invpb.RegisterInventoryServiceServer(grpcServer, invSrv)
+
+	// Health: magazyn jest już wczytany, więc usługa od razu zgłasza SERVING;
+	// przy wyłączaniu wszystkie statusy przechodzą na NOT_SERVING
+	healthSrv := healthcheck.Register(grpcServer, cfg.Reflection, invpb.InventoryService_ServiceDesc.ServiceName)
+	healthcheck.SetServing(healthSrv, true, invpb.InventoryService_ServiceDesc.ServiceName)
+
+	slog.Info("gRPC server listening", slog.String("address", cfg.ListenAddress))
+	// Po sygnale: koniec subskrypcji alertów, wygaszenie RPC w okresie karencji,
+	// a następnie (defer) zamknięcie rezerwacji, snapshot magazynu i wysyłka telemetrii
+	if err := lifecycle.Serve(ctx, grpcServer, lis, cfg.Timeouts.Shutdown, healthSrv.Shutdown, invSrv.Shutdown); err != nil {
+		slog.Error("gRPC server failed", slog.Any("error", err))
+	}
+	slog.Info("gRPC server stopped")
 }
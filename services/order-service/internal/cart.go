@@ -0,0 +1,75 @@
+package internal
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	invpb "Service-sharing-environment-project/proto/inventory"
+	orderpb "Service-sharing-environment-project/proto/order"
+)
+
+var (
+	errUnspecifiedAction = errors.New("unspecified action: expected ADD, UPDATE or REMOVE")
+	errNonPositiveQty    = errors.New("quantity must be positive")
+	errItemNotInCart     = errors.New("item not in session")
+	errOrderNotEditable  = errors.New("order is no longer editable")
+)
+
+// cart to stan koszyka budowanego w ramach jednej sesji BuildOrder
+type cart struct {
+	items map[string]int32
+	// orderID wskazuje szkic zamówienia zapisany po pierwszej zmianie koszyka
+	orderID string
+	// lastActive to czas ostatniego żądania sesji – po TTL bezczynności sesja wygasa
+	lastActive time.Time
+}
+
+func newCart(now time.Time) *cart {
+	return &cart{items: make(map[string]int32), lastActive: now}
+}
+
+// target wylicza ilość pozycji po zastosowaniu akcji (0 oznacza usunięcie pozycji)
+func (c *cart) target(req *invpb.OrderItemRequest) (int32, error) {
+	current, exists := c.items[req.ProductId]
+	switch req.Action {
+	case invpb.OrderItemRequest_ADD:
+		if req.RequestedQuantity <= 0 {
+			return 0, errNonPositiveQty
+		}
+		return current + req.RequestedQuantity, nil
+	case invpb.OrderItemRequest_UPDATE:
+		if !exists {
+			return 0, errItemNotInCart
+		}
+		if req.RequestedQuantity <= 0 {
+			return 0, errNonPositiveQty
+		}
+		return req.RequestedQuantity, nil
+	case invpb.OrderItemRequest_REMOVE:
+		if !exists {
+			return 0, errItemNotInCart
+		}
+		return 0, nil
+	default:
+		return 0, errUnspecifiedAction
+	}
+}
+
+func (c *cart) set(productID string, quantity int32) {
+	if quantity <= 0 {
+		delete(c.items, productID)
+		return
+	}
+	c.items[productID] = quantity
+}
+
+// orderItems zwraca pozycje koszyka w stałej kolejności
+func (c *cart) orderItems() []*orderpb.OrderItem {
+	items := make([]*orderpb.OrderItem, 0, len(c.items))
+	for pid, qty := range c.items {
+		items = append(items, &orderpb.OrderItem{ProductId: pid, Quantity: qty})
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].ProductId < items[j].ProductId })
+	return items
+}
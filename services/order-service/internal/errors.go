@@ -0,0 +1,97 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+
+	orderpb "Service-sharing-environment-project/proto/order"
+	"Service-sharing-environment-project/rpcerrors"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+)
+
+const resourceSession = "session"
+
+// validateFinalizeRequest sprawdza identyfikator sesji i pozycje przekazane wprost w żądaniu
+func validateFinalizeRequest(req *orderpb.FinalizeOrderRequest) error {
+	var violations []*errdetails.BadRequest_FieldViolation
+	if req.SessionId == "" {
+		violations = append(violations, rpcerrors.Field("session_id", "must not be empty"))
+	}
+	for i, item := range req.Items {
+		if item.ProductId == "" {
+			violations = append(violations,
+				rpcerrors.Field(fmt.Sprintf("items[%d].product_id", i), "must not be empty"))
+		}
+		if item.Quantity <= 0 {
+			violations = append(violations,
+				rpcerrors.Field(fmt.Sprintf("items[%d].quantity", i), "must be positive"))
+		}
+	}
+	if len(violations) > 0 {
+		return rpcerrors.InvalidArgument("invalid order request", violations...)
+	}
+	return nil
+}
+
+// stockViolations opisuje pozycje, których nie udało się zarezerwować
+func stockViolations(results []*orderpb.ItemResult) []*errdetails.PreconditionFailure_Violation {
+	var violations []*errdetails.PreconditionFailure_Violation
+	for _, r := range results {
+		if !r.Reserved {
+			violations = append(violations, rpcerrors.Violation("STOCK", "product/"+r.ProductId, r.Message))
+		}
+	}
+	return violations
+}
+
+// requireOrderID odrzuca żądania bez identyfikatora zamówienia
+func requireOrderID(orderID string) error {
+	if orderID == "" {
+		return rpcerrors.InvalidArgument("order_id is required",
+			rpcerrors.Field("order_id", "must not be empty"))
+	}
+	return nil
+}
+
+// validateListOrdersRequest sprawdza filtry, rozmiar strony i token kursora
+func validateListOrdersRequest(req *orderpb.ListOrdersRequest) error {
+	var violations []*errdetails.BadRequest_FieldViolation
+	for i, st := range req.States {
+		if _, ok := orderpb.OrderState_name[int32(st)]; !ok || st == orderpb.OrderState_ORDER_STATE_UNSPECIFIED {
+			violations = append(violations,
+				rpcerrors.Field(fmt.Sprintf("states[%d]", i), "must be a known order state"))
+		}
+	}
+	if req.CreatedAfter != nil && req.CreatedBefore != nil &&
+		!req.CreatedAfter.AsTime().Before(req.CreatedBefore.AsTime()) {
+		violations = append(violations, rpcerrors.Field("created_before", "must be after created_after"))
+	}
+	if req.PageSize < 0 {
+		violations = append(violations, rpcerrors.Field("page_size", "must not be negative"))
+	}
+	if _, err := decodePageToken(req.PageToken); err != nil {
+		violations = append(violations, rpcerrors.Field("page_token", err.Error()))
+	}
+	if len(violations) > 0 {
+		return rpcerrors.InvalidArgument("invalid list request", violations...)
+	}
+	return nil
+}
+
+// orderError mapuje błąd magazynu zamówień na status gRPC (NotFound lub Internal)
+func orderError(orderID string, err error) error {
+	if errors.Is(err, ErrOrderNotFound) {
+		return rpcerrors.NotFound(resourceOrder, orderID)
+	}
+	return rpcerrors.Internal(err)
+}
+
+// validateCancelRequest wymaga sesji albo identyfikatora zamówienia
+func validateCancelRequest(req *orderpb.CancelOrderRequest) error {
+	if req.SessionId == "" && req.OrderId == "" {
+		return rpcerrors.InvalidArgument("session_id or order_id is required",
+			rpcerrors.Field("session_id", "must not be empty when order_id is empty"))
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package internal
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// Wyniki zamówień (atrybut outcome licznika order_outcomes_total)
+const (
+	outcomeFinalized       = "finalized"
+	outcomePartiallyFailed = "partially_failed"
+	outcomeRejected        = "rejected"
+	outcomeCancelled       = "cancelled"
+	outcomeExpired         = "expired"
+)
+
+// registerMetrics rejestruje gauge otwartych sesji i koszyków oraz liczniki wyników zamówień
+// i wygasłych sesji
+func (s *OrderServer) registerMetrics(meter metric.Meter) error {
+	sessions, err := meter.Int64ObservableGauge(
+		"order_open_sessions",
+		metric.WithDescription("Number of order sessions with a cart that was neither finalized nor cancelled"),
+		metric.WithUnit("{session}"),
+	)
+	if err != nil {
+		return err
+	}
+	cartItems, err := meter.Int64ObservableGauge(
+		"order_cart_items",
+		metric.WithDescription("Number of distinct products in all open carts"),
+		metric.WithUnit("{item}"),
+	)
+	if err != nil {
+		return err
+	}
+	cartUnits, err := meter.Int64ObservableGauge(
+		"order_cart_units",
+		metric.WithDescription("Total quantity of products in all open carts"),
+		metric.WithUnit("{item}"),
+	)
+	if err != nil {
+		return err
+	}
+	s.outcomes, err = meter.Int64Counter(
+		"order_outcomes_total",
+		metric.WithDescription("Finished orders by outcome (finalized, partially_failed, rejected, cancelled, expired)"),
+		metric.WithUnit("{order}"),
+	)
+	if err != nil {
+		return err
+	}
+	s.expired, err = meter.Int64Counter(
+		"order_sessions_expired_total",
+		metric.WithDescription("Sessions expired after the idle TTL, with their inventory holds released"),
+		metric.WithUnit("{session}"),
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = meter.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		var items, units int64
+		for _, c := range s.sessions {
+			items += int64(len(c.items))
+			for _, qty := range c.items {
+				units += int64(qty)
+			}
+		}
+		o.ObserveInt64(sessions, int64(len(s.sessions)))
+		o.ObserveInt64(cartItems, items)
+		o.ObserveInt64(cartUnits, units)
+		return nil
+	}, sessions, cartItems, cartUnits)
+	return err
+}
+
+// recordOutcome zlicza zakończone zamówienie
+func (s *OrderServer) recordOutcome(ctx context.Context, outcome string) {
+	s.outcomes.Add(ctx, 1, metric.WithAttributes(attribute.String("outcome", outcome)))
+}
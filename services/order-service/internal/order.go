@@ -0,0 +1,94 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	orderpb "Service-sharing-environment-project/proto/order"
+
+	"github.com/google/uuid"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const resourceOrder = "order"
+
+// ErrInvalidTransition zwracany, gdy zamówienie nie może przejść do żądanego stanu
+var ErrInvalidTransition = errors.New("invalid order state transition")
+
+// orderTransitions to dozwolone przejścia cyklu życia zamówienia;
+// stany końcowe (CANCELLED, FAILED) nie mają wpisów; anulowanie potwierdzonego
+// zamówienia oddaje zdjęty towar do magazynu
+var orderTransitions = map[orderpb.OrderState][]orderpb.OrderState{
+	orderpb.OrderState_DRAFT: {
+		orderpb.OrderState_RESERVED,
+		orderpb.OrderState_CONFIRMED,
+		orderpb.OrderState_PARTIALLY_FULFILLED,
+		orderpb.OrderState_FAILED,
+		orderpb.OrderState_CANCELLED,
+	},
+	orderpb.OrderState_RESERVED: {
+		orderpb.OrderState_CONFIRMED,
+		orderpb.OrderState_PARTIALLY_FULFILLED,
+		orderpb.OrderState_CANCELLED,
+	},
+	orderpb.OrderState_CONFIRMED:           {orderpb.OrderState_CANCELLED},
+	orderpb.OrderState_PARTIALLY_FULFILLED: {orderpb.OrderState_CANCELLED},
+}
+
+// newOrder tworzy szkic zamówienia z nowym identyfikatorem
+func newOrder(sessionID string, now time.Time) *orderpb.Order {
+	ts := timestamppb.New(now)
+	return &orderpb.Order{
+		OrderId:   uuid.NewString(),
+		SessionId: sessionID,
+		State:     orderpb.OrderState_DRAFT,
+		CreatedAt: ts,
+		UpdatedAt: ts,
+	}
+}
+
+// canTransition zwraca true, gdy cykl życia pozwala przejść ze stanu from do to
+func canTransition(from, to orderpb.OrderState) bool {
+	return slices.Contains(orderTransitions[from], to)
+}
+
+// transition przestawia zamówienie w stan to, jeśli pozwala na to cykl życia
+func transition(o *orderpb.Order, to orderpb.OrderState, now time.Time) error {
+	if !canTransition(o.State, to) {
+		return fmt.Errorf("%w: %s -> %s", ErrInvalidTransition, o.State, to)
+	}
+	o.State = to
+	o.UpdatedAt = timestamppb.New(now)
+	return nil
+}
+
+// isFinal zwraca true dla stanu, z którego nie prowadzi żadne przejście
+func isFinal(state orderpb.OrderState) bool {
+	return len(orderTransitions[state]) == 0
+}
+
+// orderLines zamienia pozycje żądania na pozycje zamówienia
+func orderLines(items []*orderpb.OrderItem) []*orderpb.OrderLine {
+	lines := make([]*orderpb.OrderLine, len(items))
+	for i, item := range items {
+		lines[i] = &orderpb.OrderLine{ProductId: item.ProductId, Quantity: item.Quantity}
+	}
+	return lines
+}
+
+// allReserved zwraca true, gdy każda pozycja zamówienia została zdjęta z magazynu
+func allReserved(o *orderpb.Order) bool {
+	for _, line := range o.Items {
+		if !line.Reserved {
+			return false
+		}
+	}
+	return true
+}
+
+func cloneOrder(o *orderpb.Order) *orderpb.Order {
+	return proto.Clone(o).(*orderpb.Order)
+}
@@ -0,0 +1,73 @@
+package internal
+
+import (
+	"sync"
+
+	orderpb "Service-sharing-environment-project/proto/order"
+)
+
+const subscriberBuffer = 64
+
+// orderEvent informuje o przejściu zamówienia do nowego stanu
+type orderEvent struct {
+	Order    *orderpb.Order
+	Previous orderpb.OrderState
+}
+
+// orderSubscription to kanał zdarzeń jednego subskrybenta; lagged sygnalizuje,
+// że część zdarzeń przepadła i subskrybent powinien odczytać stan od nowa
+type orderSubscription struct {
+	events chan orderEvent
+	lagged chan struct{}
+}
+
+// orderBus to wewnętrzna magistrala przejść stanów zamówień.
+// Publikacja nigdy nie blokuje – wolny subskrybent dostaje sygnał lagged.
+type orderBus struct {
+	mu     sync.Mutex
+	nextID int
+	subs   map[int]*orderSubscription
+}
+
+func newOrderBus() *orderBus {
+	return &orderBus{subs: make(map[int]*orderSubscription)}
+}
+
+// subscribe rejestruje subskrybenta; zwrócona funkcja go wyrejestrowuje
+func (b *orderBus) subscribe() (*orderSubscription, func()) {
+	sub := &orderSubscription{
+		events: make(chan orderEvent, subscriberBuffer),
+		lagged: make(chan struct{}, 1),
+	}
+
+	b.mu.Lock()
+	id := b.nextID
+	b.nextID++
+	b.subs[id] = sub
+	b.mu.Unlock()
+
+	return sub, func() {
+		b.mu.Lock()
+		delete(b.subs, id)
+		b.mu.Unlock()
+	}
+}
+
+// publish rozsyła kopię zamówienia, więc subskrybenci nie współdzielą go z serwerem
+func (b *orderBus) publish(o *orderpb.Order, previous orderpb.OrderState) {
+	ev := orderEvent{Order: cloneOrder(o), Previous: previous}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, sub := range b.subs {
+		select {
+		case sub.events <- ev:
+		default:
+			select {
+			case sub.lagged <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
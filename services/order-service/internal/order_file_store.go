@@ -0,0 +1,132 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	orderpb "Service-sharing-environment-project/proto/order"
+	"Service-sharing-environment-project/walstore"
+
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+const (
+	orderSnapshotFileName = "orders.snapshot.json"
+	orderWALFileName      = "orders.wal.log"
+)
+
+type orderSnapshotFile struct {
+	Orders []json.RawMessage `json:"orders"`
+}
+
+// FileOrderStore to trwały OrderStore: każdy zapis zamówienia trafia najpierw
+// do write‐ahead logu (jedna linia protojson, fsync), a okresowy snapshot
+// pozwala ten log obciąć. Przy starcie stan odtwarzany jest ze snapshotu i dziennika (pakiet walstore).
+type FileOrderStore struct {
+	mem *MemoryOrderStore
+	log *walstore.Log
+}
+
+// OpenFileOrderStore odtwarza stan z katalogu dir i uruchamia okresowe snapshoty
+func OpenFileOrderStore(dir string, snapshotInterval time.Duration) (*FileOrderStore, error) {
+	mem := NewMemoryOrderStore()
+	log, err := walstore.Open(walstore.Options{
+		Dir:              dir,
+		SnapshotFile:     orderSnapshotFileName,
+		WALFile:          orderWALFileName,
+		SnapshotInterval: snapshotInterval,
+		Component:        "FileOrderStore",
+	}, orderState{mem})
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("order store recovered",
+		slog.String("component", "FileOrderStore"),
+		slog.String("dir", dir),
+		slog.Int("orders", len(mem.orders)),
+		slog.Int("wal_entries", log.Entries()),
+	)
+	return &FileOrderStore{mem: mem, log: log}, nil
+}
+
+func (s *FileOrderStore) Get(id string) (*orderpb.Order, error) {
+	return s.mem.Get(id)
+}
+
+func (s *FileOrderStore) List() ([]*orderpb.Order, error) {
+	return s.mem.List()
+}
+
+func (s *FileOrderStore) Page(after *pageCursor, limit int, match func(*orderpb.Order) bool) ([]*orderpb.Order, bool, error) {
+	return s.mem.Page(after, limit, match)
+}
+
+func (s *FileOrderStore) Put(o *orderpb.Order) error {
+	line, err := protojson.Marshal(o)
+	if err != nil {
+		return err
+	}
+	return s.log.Update(func(appendRecord func([]byte) error) error {
+		if err := appendRecord(line); err != nil {
+			return err
+		}
+		return s.mem.Put(o)
+	})
+}
+
+// Close zatrzymuje snapshoty, zapisuje końcowy snapshot i zamyka dziennik
+func (s *FileOrderStore) Close() error {
+	return s.log.Close()
+}
+
+// Snapshot zapisuje pełny stan na dysk i obcina write‐ahead log
+func (s *FileOrderStore) Snapshot() error {
+	return s.log.Snapshot()
+}
+
+// orderState odtwarza i zapisuje zamówienia MemoryOrderStore na potrzeby walstore
+type orderState struct {
+	mem *MemoryOrderStore
+}
+
+func (st orderState) Restore(data []byte) error {
+	var snap orderSnapshotFile
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return err
+	}
+	for _, raw := range snap.Orders {
+		o := &orderpb.Order{}
+		if err := protojson.Unmarshal(raw, o); err != nil {
+			return fmt.Errorf("decode snapshot order: %w", err)
+		}
+		st.mem.storeLocked(o)
+	}
+	return nil
+}
+
+func (st orderState) Replay(line []byte) error {
+	o := &orderpb.Order{}
+	if err := protojson.Unmarshal(line, o); err != nil {
+		return err
+	}
+	st.mem.storeLocked(o)
+	return nil
+}
+
+func (st orderState) Snapshot() ([]byte, error) {
+	orders, err := st.mem.List()
+	if err != nil {
+		return nil, err
+	}
+	snap := orderSnapshotFile{Orders: make([]json.RawMessage, 0, len(orders))}
+	for _, o := range orders {
+		raw, err := protojson.Marshal(o)
+		if err != nil {
+			return nil, err
+		}
+		snap.Orders = append(snap.Orders, raw)
+	}
+	return json.Marshal(snap)
+}
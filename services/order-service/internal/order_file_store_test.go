@@ -0,0 +1,49 @@
+package internal
+
+import (
+	"testing"
+	"time"
+
+	orderpb "Service-sharing-environment-project/proto/order"
+)
+
+func TestFileOrderStoreRecoversLatestOrderVersion(t *testing.T) {
+	dir := t.TempDir()
+	s, err := OpenFileOrderStore(dir, time.Hour)
+	if err != nil {
+		t.Fatalf("OpenFileOrderStore: %v", err)
+	}
+	o := &orderpb.Order{OrderId: "O1", SessionId: "S1", State: orderpb.OrderState_DRAFT}
+	if err := s.Put(o); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if err := s.Snapshot(); err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+	// Nowsza wersja zamówienia jest już tylko w dzienniku
+	if err := s.Put(&orderpb.Order{OrderId: "O1", SessionId: "S1", State: orderpb.OrderState_CONFIRMED}); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if err := s.Put(&orderpb.Order{OrderId: "O2", SessionId: "S2", State: orderpb.OrderState_DRAFT}); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	s, err = OpenFileOrderStore(dir, time.Hour)
+	if err != nil {
+		t.Fatalf("OpenFileOrderStore: %v", err)
+	}
+	defer s.Close()
+	got, err := s.Get("O1")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if got.State != orderpb.OrderState_CONFIRMED {
+		t.Errorf("O1 state %s, want CONFIRMED", got.State)
+	}
+	if _, err := s.Get("O2"); err != nil {
+		t.Errorf("Get O2: %v", err)
+	}
+}
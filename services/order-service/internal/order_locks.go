@@ -0,0 +1,47 @@
+package internal
+
+import "sync"
+
+// sessionLocks szereguje operacje zmieniające zamówienie sesji (akcje koszyka,
+// ConfirmOrderStock, FinalizeOrder, anulowanie). Blokada jest per sesja, a nie per
+// identyfikator zamówienia: sesja ma naraz co najwyżej jedno otwarte zamówienie,
+// a jego szkic powstaje dopiero przy pierwszej akcji koszyka.
+// Stan zamówienia trzeba odczytać ponownie już po wzięciu blokady.
+type sessionLocks struct {
+	mu    sync.Mutex
+	locks map[string]*sessionLock
+}
+
+type sessionLock struct {
+	mu sync.Mutex
+	// refs liczy trzymających i czekających – wpis znika, gdy nikt go nie używa
+	refs int
+}
+
+func newSessionLocks() *sessionLocks {
+	return &sessionLocks{locks: make(map[string]*sessionLock)}
+}
+
+// lock blokuje sesję; zwrócona funkcja zwalnia blokadę
+func (l *sessionLocks) lock(sessionID string) func() {
+	l.mu.Lock()
+	sl, ok := l.locks[sessionID]
+	if !ok {
+		sl = &sessionLock{}
+		l.locks[sessionID] = sl
+	}
+	sl.refs++
+	l.mu.Unlock()
+
+	sl.mu.Lock()
+	return func() {
+		sl.mu.Unlock()
+
+		l.mu.Lock()
+		sl.refs--
+		if sl.refs == 0 {
+			delete(l.locks, sessionID)
+		}
+		l.mu.Unlock()
+	}
+}
@@ -0,0 +1,93 @@
+package internal
+
+import (
+	"encoding/base64"
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	orderpb "Service-sharing-environment-project/proto/order"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+)
+
+var errInvalidPageToken = errors.New("invalid page token")
+
+// pageCursor wskazuje ostatnie zamówienie poprzedniej strony w kolejności List
+// (czas utworzenia, a przy remisie identyfikator)
+type pageCursor struct {
+	createdAt time.Time
+	orderID   string
+}
+
+// encodePageToken zwraca nieprzezroczysty token strony następującej po o
+func encodePageToken(o *orderpb.Order) string {
+	raw := strconv.FormatInt(o.CreatedAt.AsTime().UnixNano(), 10) + ":" + o.OrderId
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// decodePageToken odczytuje token; pusty token oznacza pierwszą stronę (nil)
+func decodePageToken(token string) (*pageCursor, error) {
+	if token == "" {
+		return nil, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, errInvalidPageToken
+	}
+	nanos, id, ok := strings.Cut(string(raw), ":")
+	if !ok || id == "" {
+		return nil, errInvalidPageToken
+	}
+	n, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return nil, errInvalidPageToken
+	}
+	return &pageCursor{createdAt: time.Unix(0, n), orderID: id}, nil
+}
+
+// precedes zwraca true, gdy zamówienie o leży w kolejności List za kursorem
+func (c *pageCursor) precedes(o *orderpb.Order) bool {
+	t := o.CreatedAt.AsTime()
+	if !t.Equal(c.createdAt) {
+		return t.After(c.createdAt)
+	}
+	return o.OrderId > c.orderID
+}
+
+// pageSize zwraca liczbę zamówień na stronie (domyślna lub przycięta do maksimum)
+func pageSize(requested int32) int {
+	switch {
+	case requested <= 0:
+		return defaultPageSize
+	case requested > maxPageSize:
+		return maxPageSize
+	default:
+		return int(requested)
+	}
+}
+
+// matchesFilter sprawdza filtry stanu, produktu i czasu utworzenia z ListOrdersRequest
+func matchesFilter(req *orderpb.ListOrdersRequest, o *orderpb.Order) bool {
+	if len(req.States) > 0 && !slices.Contains(req.States, o.State) {
+		return false
+	}
+	if req.ProductId != "" && !slices.ContainsFunc(o.Items, func(line *orderpb.OrderLine) bool {
+		return line.ProductId == req.ProductId
+	}) {
+		return false
+	}
+	created := o.CreatedAt.AsTime()
+	if req.CreatedAfter != nil && created.Before(req.CreatedAfter.AsTime()) {
+		return false
+	}
+	if req.CreatedBefore != nil && !created.Before(req.CreatedBefore.AsTime()) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,118 @@
+package internal
+
+import (
+	"context"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	orderpb "Service-sharing-environment-project/proto/order"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestPageTokenRoundTrip(t *testing.T) {
+	o := newOrder("S1", time.Unix(1700000000, 123456789))
+	cursor, err := decodePageToken(encodePageToken(o))
+	if err != nil {
+		t.Fatalf("decodePageToken: %v", err)
+	}
+	if !cursor.createdAt.Equal(o.CreatedAt.AsTime()) || cursor.orderID != o.OrderId {
+		t.Errorf("cursor %v/%s, want %v/%s", cursor.createdAt, cursor.orderID, o.CreatedAt.AsTime(), o.OrderId)
+	}
+
+	if cursor, err := decodePageToken(""); cursor != nil || err != nil {
+		t.Errorf("empty token: cursor %v, error %v; want first page", cursor, err)
+	}
+}
+
+func TestDecodePageTokenRejectsInvalid(t *testing.T) {
+	encode := func(raw string) string { return base64.RawURLEncoding.EncodeToString([]byte(raw)) }
+	for _, token := range []string{
+		"not base64!",
+		encode("1700000000"),
+		encode("1700000000:"),
+		encode("yesterday:O1"),
+	} {
+		if _, err := decodePageToken(token); err != errInvalidPageToken {
+			t.Errorf("decodePageToken(%q): error %v, want %v", token, err, errInvalidPageToken)
+		}
+	}
+}
+
+func TestPageCursorPrecedes(t *testing.T) {
+	at := time.Unix(1700000000, 0)
+	cursor := &pageCursor{createdAt: at, orderID: "B"}
+	for _, tt := range []struct {
+		created time.Time
+		id      string
+		want    bool
+	}{
+		{at.Add(-time.Nanosecond), "Z", false},
+		{at, "A", false},
+		{at, "B", false},
+		{at, "C", true},
+		{at.Add(time.Nanosecond), "A", true},
+	} {
+		o := newOrder("S1", tt.created)
+		o.OrderId = tt.id
+		if got := cursor.precedes(o); got != tt.want {
+			t.Errorf("precedes(%v, %s) = %v, want %v", tt.created, tt.id, got, tt.want)
+		}
+	}
+}
+
+func TestPageSize(t *testing.T) {
+	for requested, want := range map[int32]int{-1: defaultPageSize, 0: defaultPageSize, 7: 7, maxPageSize + 1: maxPageSize} {
+		if got := pageSize(requested); got != want {
+			t.Errorf("pageSize(%d) = %d, want %d", requested, got, want)
+		}
+	}
+}
+
+func TestListOrdersPagesThroughAllOrders(t *testing.T) {
+	s := newTestOrderServer(t, &fakeInventory{})
+	// Po dwa zamówienia z tym samym czasem utworzenia – kolejność rozstrzyga identyfikator
+	created := time.Now()
+	want := map[string]bool{}
+	for i := range 5 {
+		o := newOrder("S1", created.Add(time.Duration(i/2)*time.Second))
+		if err := s.orders.Put(o); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+		want[o.OrderId] = true
+	}
+
+	ctx := context.Background()
+	req := &orderpb.ListOrdersRequest{PageSize: 2}
+	var pages int
+	for {
+		resp, err := s.ListOrders(ctx, req)
+		if err != nil {
+			t.Fatalf("ListOrders: %v", err)
+		}
+		pages++
+		for _, o := range resp.Orders {
+			if !want[o.OrderId] {
+				t.Errorf("order %s listed twice or unknown", o.OrderId)
+			}
+			delete(want, o.OrderId)
+		}
+		if resp.NextPageToken == "" {
+			break
+		}
+		req.PageToken = resp.NextPageToken
+	}
+	if len(want) != 0 {
+		t.Errorf("%d orders never listed", len(want))
+	}
+	if pages != 3 {
+		t.Errorf("%d pages, want 3", pages)
+	}
+
+	_, err := s.ListOrders(ctx, &orderpb.ListOrdersRequest{PageToken: "not base64!"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("invalid page token: error %v, want InvalidArgument", err)
+	}
+}
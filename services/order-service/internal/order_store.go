@@ -0,0 +1,151 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	orderpb "Service-sharing-environment-project/proto/order"
+)
+
+// ErrOrderNotFound zwracany przez OrderStore, gdy zamówienie nie istnieje
+var ErrOrderNotFound = errors.New("order not found")
+
+const (
+	StoreKindMemory = "memory"
+	StoreKindFile   = "file"
+)
+
+// OrderStore abstrahuje miejsce przechowywania zamówień.
+// Zwracane zamówienia są kopiami – zmiana wymaga wywołania Put.
+type OrderStore interface {
+	Get(id string) (*orderpb.Order, error)
+	Put(o *orderpb.Order) error
+	// List zwraca wszystkie zamówienia od najstarszego
+	List() ([]*orderpb.Order, error)
+	// Page zwraca w kolejności List do limit zamówień spełniających match,
+	// leżących za after (nil – od początku); more mówi, czy za stroną są kolejne.
+	// match dostaje zamówienie z magazynu – nie może go zmieniać ani zachować
+	Page(after *pageCursor, limit int, match func(*orderpb.Order) bool) (orders []*orderpb.Order, more bool, err error)
+	Close() error
+}
+
+// OrderStoreConfig opisuje, który OrderStore ma zostać utworzony
+type OrderStoreConfig struct {
+	Kind             string
+	DataDir          string
+	SnapshotInterval time.Duration
+}
+
+// OpenOrderStore tworzy OrderStore wg konfiguracji
+func OpenOrderStore(cfg OrderStoreConfig) (OrderStore, error) {
+	switch cfg.Kind {
+	case "", StoreKindMemory:
+		return NewMemoryOrderStore(), nil
+	case StoreKindFile:
+		return OpenFileOrderStore(cfg.DataDir, cfg.SnapshotInterval)
+	default:
+		return nil, fmt.Errorf("unknown store kind %q", cfg.Kind)
+	}
+}
+
+// MemoryOrderStore to OrderStore trzymający zamówienia wyłącznie w pamięci.
+// Zamówienia nie są usuwane; sorted pozwala stronicować bez sortowania
+// i kopiowania wszystkich zamówień
+type MemoryOrderStore struct {
+	mu     sync.RWMutex
+	orders map[string]*orderpb.Order
+	// sorted to te same zamówienia w kolejności List
+	sorted []*orderpb.Order
+}
+
+// NewMemoryOrderStore tworzy pusty magazyn zamówień w pamięci
+func NewMemoryOrderStore() *MemoryOrderStore {
+	return &MemoryOrderStore{orders: make(map[string]*orderpb.Order)}
+}
+
+func (s *MemoryOrderStore) Get(id string) (*orderpb.Order, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	o, ok := s.orders[id]
+	if !ok {
+		return nil, ErrOrderNotFound
+	}
+	return cloneOrder(o), nil
+}
+
+func (s *MemoryOrderStore) Put(o *orderpb.Order) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.storeLocked(cloneOrder(o))
+	return nil
+}
+
+// storeLocked zapisuje o bez kopiowania i utrzymuje kolejność sorted; wywoływane pod s.mu
+func (s *MemoryOrderStore) storeLocked(o *orderpb.Order) {
+	if old, ok := s.orders[o.OrderId]; ok {
+		i := s.position(old)
+		if !orderBefore(old, o) && !orderBefore(o, old) {
+			// Czas utworzenia się nie zmienił – zamówienie zostaje na swoim miejscu
+			s.sorted[i] = o
+			s.orders[o.OrderId] = o
+			return
+		}
+		s.sorted = slices.Delete(s.sorted, i, i+1)
+	}
+	s.sorted = slices.Insert(s.sorted, s.position(o), o)
+	s.orders[o.OrderId] = o
+}
+
+// position zwraca indeks, pod którym o leży (lub powinno leżeć) w sorted
+func (s *MemoryOrderStore) position(o *orderpb.Order) int {
+	return sort.Search(len(s.sorted), func(i int) bool { return !orderBefore(s.sorted[i], o) })
+}
+
+func (s *MemoryOrderStore) List() ([]*orderpb.Order, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	out := make([]*orderpb.Order, 0, len(s.sorted))
+	for _, o := range s.sorted {
+		out = append(out, cloneOrder(o))
+	}
+	return out, nil
+}
+
+func (s *MemoryOrderStore) Page(after *pageCursor, limit int, match func(*orderpb.Order) bool) ([]*orderpb.Order, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	start := 0
+	if after != nil {
+		start = sort.Search(len(s.sorted), func(i int) bool { return after.precedes(s.sorted[i]) })
+	}
+	var page []*orderpb.Order
+	for _, o := range s.sorted[start:] {
+		if !match(o) {
+			continue
+		}
+		if len(page) == limit {
+			return page, true, nil
+		}
+		page = append(page, cloneOrder(o))
+	}
+	return page, false, nil
+}
+
+func (s *MemoryOrderStore) Close() error { return nil }
+
+// orderBefore porządkuje zamówienia wg czasu utworzenia, a przy remisie wg identyfikatora
+func orderBefore(a, b *orderpb.Order) bool {
+	ta, tb := a.CreatedAt.AsTime(), b.CreatedAt.AsTime()
+	if !ta.Equal(tb) {
+		return ta.Before(tb)
+	}
+	return a.OrderId < b.OrderId
+}
@@ -0,0 +1,67 @@
+package internal
+
+import (
+	"testing"
+	"time"
+
+	orderpb "Service-sharing-environment-project/proto/order"
+)
+
+func TestMemoryOrderStorePagesInListOrder(t *testing.T) {
+	s := NewMemoryOrderStore()
+	created := time.Unix(1700000000, 0)
+	// Zamówienia trafiają do magazynu w innej kolejności niż czas utworzenia
+	for _, offset := range []int{3, 0, 2, 1} {
+		o := newOrder("S1", created.Add(time.Duration(offset)*time.Second))
+		o.OrderId = string(rune('A' + offset))
+		if err := s.Put(o); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+	}
+	// Zmiana zamówienia nie przesuwa go w kolejności
+	o, err := s.Get("B")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	o.State = orderpb.OrderState_CANCELLED
+	if err := s.Put(o); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	all := func(*orderpb.Order) bool { return true }
+	page, more, err := s.Page(nil, 2, all)
+	if err != nil {
+		t.Fatalf("Page: %v", err)
+	}
+	if !more || len(page) != 2 || page[0].OrderId != "A" || page[1].OrderId != "B" {
+		t.Fatalf("first page %v (more %v), want A, B and more", orderIDs(page), more)
+	}
+	if page[1].State != orderpb.OrderState_CANCELLED {
+		t.Errorf("B listed as %s, want CANCELLED", page[1].State)
+	}
+	// Strona to kopie – zmiana nie trafia do magazynu
+	page[0].State = orderpb.OrderState_FAILED
+	if o, _ := s.Get("A"); o.State == orderpb.OrderState_FAILED {
+		t.Error("changing a listed order changed the store")
+	}
+
+	cursor, err := decodePageToken(encodePageToken(page[1]))
+	if err != nil {
+		t.Fatalf("decodePageToken: %v", err)
+	}
+	page, more, err = s.Page(cursor, 2, func(o *orderpb.Order) bool { return o.OrderId != "C" })
+	if err != nil {
+		t.Fatalf("Page: %v", err)
+	}
+	if more || len(page) != 1 || page[0].OrderId != "D" {
+		t.Errorf("filtered second page %v (more %v), want D and no more", orderIDs(page), more)
+	}
+}
+
+func orderIDs(orders []*orderpb.Order) []string {
+	ids := make([]string, 0, len(orders))
+	for _, o := range orders {
+		ids = append(ids, o.OrderId)
+	}
+	return ids
+}
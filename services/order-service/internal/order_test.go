@@ -0,0 +1,89 @@
+package internal
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	orderpb "Service-sharing-environment-project/proto/order"
+)
+
+func TestCanTransition(t *testing.T) {
+	const (
+		draft     = orderpb.OrderState_DRAFT
+		reserved  = orderpb.OrderState_RESERVED
+		confirmed = orderpb.OrderState_CONFIRMED
+		partial   = orderpb.OrderState_PARTIALLY_FULFILLED
+		failed    = orderpb.OrderState_FAILED
+		cancelled = orderpb.OrderState_CANCELLED
+	)
+	tests := []struct {
+		from, to orderpb.OrderState
+		want     bool
+	}{
+		{draft, reserved, true},
+		{draft, confirmed, true},
+		{draft, partial, true},
+		{draft, failed, true},
+		{draft, cancelled, true},
+		{reserved, confirmed, true},
+		{reserved, partial, true},
+		{reserved, cancelled, true},
+		{confirmed, cancelled, true},
+		{partial, cancelled, true},
+
+		// ConfirmOrderStock zdejmuje towar tylko raz, a porażka zamyka tylko szkic
+		{reserved, reserved, false},
+		{reserved, failed, false},
+		{reserved, draft, false},
+		{confirmed, confirmed, false},
+		{confirmed, partial, false},
+		{partial, confirmed, false},
+		{failed, cancelled, false},
+		{cancelled, draft, false},
+		{cancelled, cancelled, false},
+		{orderpb.OrderState_ORDER_STATE_UNSPECIFIED, draft, false},
+	}
+	for _, tt := range tests {
+		if got := canTransition(tt.from, tt.to); got != tt.want {
+			t.Errorf("canTransition(%s, %s) = %v, want %v", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
+
+func TestTransition(t *testing.T) {
+	created := time.Now().Add(-time.Minute)
+	o := newOrder("S1", created)
+	now := time.Now()
+
+	if err := transition(o, orderpb.OrderState_RESERVED, now); err != nil {
+		t.Fatalf("DRAFT -> RESERVED: %v", err)
+	}
+	if o.State != orderpb.OrderState_RESERVED || !o.UpdatedAt.AsTime().Equal(now) {
+		t.Errorf("after transition: state %s, updated %v", o.State, o.UpdatedAt.AsTime())
+	}
+
+	// Odrzucone przejście nie zmienia zamówienia
+	err := transition(o, orderpb.OrderState_FAILED, now.Add(time.Second))
+	if !errors.Is(err, ErrInvalidTransition) {
+		t.Fatalf("RESERVED -> FAILED: error %v, want ErrInvalidTransition", err)
+	}
+	if o.State != orderpb.OrderState_RESERVED || !o.UpdatedAt.AsTime().Equal(now) {
+		t.Errorf("rejected transition changed the order: state %s, updated %v", o.State, o.UpdatedAt.AsTime())
+	}
+}
+
+func TestIsFinal(t *testing.T) {
+	for state, want := range map[orderpb.OrderState]bool{
+		orderpb.OrderState_DRAFT:               false,
+		orderpb.OrderState_RESERVED:            false,
+		orderpb.OrderState_CONFIRMED:           false,
+		orderpb.OrderState_PARTIALLY_FULFILLED: false,
+		orderpb.OrderState_FAILED:              true,
+		orderpb.OrderState_CANCELLED:           true,
+	} {
+		if got := isFinal(state); got != want {
+			t.Errorf("isFinal(%s) = %v, want %v", state, got, want)
+		}
+	}
+}
@@ -2,242 +2,1111 @@ package internal
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"sync"
 	"time"
 
+	"Service-sharing-environment-project/config"
 	invpb "Service-sharing-environment-project/proto/inventory"
 	orderpb "Service-sharing-environment-project/proto/order"
+	"Service-sharing-environment-project/rpcerrors"
+	"Service-sharing-environment-project/telemetry"
 
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
 	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 type OrderServer struct {
-    orderpb.UnimplementedOrderServiceServer
-    inventory      invpb.InventoryServiceClient
-    sessions       map[string][]*invpb.OrderItemRequest
-    mu             sync.Mutex
-    requestCounter metric.Int64Counter
-    latencyHist    metric.Float64Histogram
-}
-
-func NewOrderServer(invClient invpb.InventoryServiceClient, m metric.Meter) *OrderServer {
-    ctr, err := m.Int64Counter("order_requests_total")
-    if err != nil {
-        panic(err)
-    }
-    hist, err := m.Float64Histogram("order_request_latency_ms")
-    if err != nil {
-        panic(err)
-    }
-
-    return &OrderServer{
-        inventory:      invClient,
-        sessions:       make(map[string][]*invpb.OrderItemRequest),
-        requestCounter: ctr,
-        latencyHist:    hist,
-    }
-}
-
-// instrument otwiera span i po zakończeniu rejestruje liczniki i histogram
-func (s *OrderServer) instrument(ctx context.Context, name string) (context.Context, func()) {
-    start := time.Now()
-    ctx, span := otel.Tracer("order-service").Start(ctx, name)
-    return ctx, func() {
-        span.End()
-        ms := float64(time.Since(start).Milliseconds())
-        s.requestCounter.Add(ctx, 1,
-            metric.WithAttributes(attribute.String("rpc", name)),
-        )
-        s.latencyHist.Record(ctx, ms,
-            metric.WithAttributes(attribute.String("rpc", name)),
-        )
-    }
+	orderpb.UnimplementedOrderServiceServer
+	inventory invpb.InventoryServiceClient
+	sessions  map[string]*cart
+	orders    OrderStore
+	mu        sync.Mutex
+	// locks szereguje zmiany zamówienia jednej sesji (koszyk, potwierdzenie,
+	// finalizacja, anulowanie i wygaszanie)
+	locks *sessionLocks
+	// bus rozgłasza przejścia stanów zamówień (WatchOrder)
+	bus *orderBus
+
+	// closing jest zamykany przy wyłączaniu serwera – kończy strumienie WatchOrder
+	closing   chan struct{}
+	closeOnce sync.Once
+
+	// sessionTTL to czas bezczynności, po którym sesja wygasa (sweepLoop)
+	sessionTTL  time.Duration
+	janitorStop chan struct{}
+	janitorDone chan struct{}
+
+	outcomes metric.Int64Counter
+	expired  metric.Int64Counter
+}
+
+// NewOrderServer tworzy serwer zamówień zapisujący zamówienia w orders i rejestruje na meter
+// metryki sesji i wyników zamówień; metryki i logi RPC rejestrują interceptory z pakietu interceptors.
+// Sesje otwartych zamówień z orders są odtwarzane, a sesje bezczynne dłużej niż
+// sessionTTL są wygaszane w tle aż do wywołania Close.
+func NewOrderServer(invClient invpb.InventoryServiceClient, orders OrderStore, meter metric.Meter, sessionTTL time.Duration) (*OrderServer, error) {
+	if sessionTTL <= 0 {
+		sessionTTL = DefaultSessionTTL
+	}
+	s := &OrderServer{
+		inventory:   invClient,
+		sessions:    make(map[string]*cart),
+		orders:      orders,
+		bus:         newOrderBus(),
+		locks:       newSessionLocks(),
+		closing:     make(chan struct{}),
+		sessionTTL:  sessionTTL,
+		janitorStop: make(chan struct{}),
+		janitorDone: make(chan struct{}),
+	}
+	if err := s.registerMetrics(meter); err != nil {
+		return nil, err
+	}
+	if err := s.restoreSessions(); err != nil {
+		return nil, err
+	}
+	go s.sweepLoop(config.SweepInterval(sessionTTL))
+	return s, nil
 }
 
 func (s *OrderServer) CheckItemAvailability(ctx context.Context, req *invpb.ProductId) (*invpb.ProductInfo, error) {
-    ctx, end := s.instrument(ctx, "CheckItemAvailability")
-    defer end()
-    
-	log.Printf("[Order][CheckItemAvailability] product_id=%s", req.ProductId)
+	slog.DebugContext(ctx, "request received",
+		slog.String("rpc.method", "CheckItemAvailability"),
+		slog.String("product_id", req.ProductId),
+	)
 	resp, err := s.inventory.GetProductInfo(ctx, req)
 	if err != nil {
-		log.Printf("[Order][CheckItemAvailability] inventory error: %v", err)
+		slog.WarnContext(ctx, "inventory call failed",
+			slog.String("rpc.method", "CheckItemAvailability"),
+			slog.Any("error", err),
+		)
 		return nil, err
 	}
-	log.Printf("[Order][CheckItemAvailability] inventory success: available_quantity=%d", resp.AvailableQuantity)
+	slog.InfoContext(ctx, "availability checked",
+		slog.String("rpc.method", "CheckItemAvailability"),
+		slog.String("product_id", req.ProductId),
+		slog.Int("available_quantity", int(resp.AvailableQuantity)),
+	)
 	return resp, nil
 }
 
+// BuildOrder przekazuje akcje koszyka do Inventory.InteractiveOrderStock (jeden strumień
+// na sesję), dzięki czemu koszyk trzyma miękkie rezerwacje; alerty niskiego stanu
+// z Inventory trafiają do klienta bez żądania
 func (s *OrderServer) BuildOrder(stream orderpb.OrderService_BuildOrderServer) error {
-    tracer := otel.Tracer("order-service")
-
-    for {
-        // 1) Span wokół Recv
-        ctxRecv, spanRecv := tracer.Start(stream.Context(), "ReceiveOrderRequest")
-        req, err := stream.Recv()
-        spanRecv.End()
-        if err == io.EOF {
-            log.Printf("[Order][BuildOrder] stream EOF")
-            return nil
-        }
-        if err != nil {
-			log.Printf("[Order][BuildOrder] Recv error: %v", err)
-            return err
-        }
-
-        log.Printf(
-			"[Order][BuildOrder] received: session_id=%s product_id=%s requested_quantity=%d",
-			req.SessionId, req.ProductId, req.RequestedQuantity,
+	tracer := otel.Tracer("order-service")
+
+	// Odpowiedzi wysyła ta pętla, a alerty gorutyny strumieni Inventory
+	var sendMu sync.Mutex
+	send := func(resp *invpb.OrderItemResponse) error {
+		sendMu.Lock()
+		defer sendMu.Unlock()
+		return stream.Send(resp)
+	}
+	// Każdy powrót z BuildOrder (i rozłączenie klienta) zamyka strumienie do Inventory
+	ctx := stream.Context()
+	relays := newStockRelays(ctx, s.inventory, send)
+	defer relays.close()
+
+	for {
+		// 1) Span wokół Recv
+		ctxRecv, spanRecv := tracer.Start(ctx, "ReceiveOrderRequest")
+		req, err := stream.Recv()
+		spanRecv.End()
+		if err == io.EOF {
+			slog.InfoContext(ctxRecv, "stream closed by client", slog.String("rpc.method", "BuildOrder"))
+			relays.closeSend()
+			return nil
+		}
+		if err != nil {
+			slog.WarnContext(ctxRecv, "receive failed",
+				slog.String("rpc.method", "BuildOrder"),
+				slog.Any("error", err),
+			)
+			return err
+		}
+
+		slog.DebugContext(ctxRecv, "request received",
+			slog.String("rpc.method", "BuildOrder"),
+			slog.String("session_id", req.SessionId),
+			slog.String("action", req.Action.String()),
+			slog.String("product_id", req.ProductId),
+			slog.Int("requested_quantity", int(req.RequestedQuantity)),
 		)
 
-        // 2) Span & metryki wokół logiki
-        ctx, end := s.instrument(ctxRecv, "BuildOrder")
-        s.mu.Lock()
-        s.sessions[req.SessionId] = append(s.sessions[req.SessionId], req)
-        s.mu.Unlock()
-		log.Printf("[Order][BuildOrder] appended to session %s", req.SessionId)
-
-        // 3) Sprawdzenie stanu magazynowego
-		log.Printf("[Order][BuildOrder] calling Inventory.GetProductInfo for product_id=%s", req.ProductId)
-        invResp, err := s.inventory.GetProductInfo(ctx, &invpb.ProductId{ProductId: req.ProductId})
-        if err != nil {
-            log.Printf("[Order][BuildOrder] Inventory.GetProductInfo error: %v", err)
-            end()
-            return err
-        }
-        log.Printf("[Order][BuildOrder] Inventory.GetProductInfo returned available_quantity=%d", invResp.AvailableQuantity)
-        available := invResp.AvailableQuantity >= req.RequestedQuantity
-
-        // 4) Zwracamy odpowiedni typ z inventory (pole AvailableQuantity)
-        resp := &invpb.OrderItemResponse{
-            ProductId:         req.ProductId,
-            Available:         available,
-            AvailableQuantity: invResp.AvailableQuantity,
-        }
-        log.Printf(
-			"[Order][BuildOrder] sending response: product_id=%s available=%v remaining=%d",
-			resp.ProductId, resp.Available, resp.AvailableQuantity,
+		// 2) Span wokół logiki pojedynczej wiadomości
+		// session_id trafia do baggage: atrybut spanów tutaj i w Inventory
+		ctx, span := tracer.Start(withSession(ctxRecv, req.SessionId), "BuildOrder")
+		resp, err := s.applyCartAction(ctx, req, relays)
+		if err != nil {
+			slog.WarnContext(ctx, "inventory call failed",
+				slog.String("rpc.method", "BuildOrder"),
+				slog.Any("error", err),
+			)
+			span.End()
+			return err
+		}
+
+		// 3) Odpowiedź per wiadomość – błędy walidacji nie zamykają strumienia
+		slog.DebugContext(ctx, "sending response",
+			slog.String("rpc.method", "BuildOrder"),
+			slog.String("product_id", resp.ProductId),
+			slog.Bool("available", resp.Available),
+			slog.Int("remaining", int(resp.AvailableQuantity)),
+			slog.String("message", resp.Message),
 		)
-        if err := stream.Send(resp); err != nil {
-            log.Printf("[Order][BuildOrder] Send error: %v", err)
-            end()
-            return err
-        }
-		log.Printf("[Order][BuildOrder] stream.Send successful")
-        end()
-    }
+		if err := send(resp); err != nil {
+			slog.WarnContext(ctx, "send failed",
+				slog.String("rpc.method", "BuildOrder"),
+				slog.Any("error", err),
+			)
+			span.End()
+			return err
+		}
+		span.End()
+	}
 }
 
-func (s *OrderServer) FinalizeOrder(ctx context.Context, req *orderpb.FinalizeOrderRequest) (*orderpb.FinalizeOrderResponse, error) {
-    ctx, end := s.instrument(ctx, "FinalizeOrder")
-    defer end()
-
-    log.Printf("[Order][FinalizeOrder] session_id=%s items_count=%d", req.SessionId, len(req.Items))
-        
-    var results []*orderpb.ItemResult
-    okAll := true
-
-    for _, item := range req.GetItems() {
-        log.Printf("[Order][FinalizeOrder] checking product_id=%s quantity=%d", item.ProductId, item.Quantity)
-        prod, err := s.inventory.GetProductInfo(ctx, &invpb.ProductId{ProductId: item.ProductId})
-        res := &orderpb.ItemResult{ProductId: item.ProductId}
-
-        if err != nil || prod.GetAvailableQuantity() < item.GetQuantity() {
-			availableQty := int32(0)
-			if prod != nil {
-				availableQty = prod.GetAvailableQuantity()
+// applyCartAction stosuje akcję ADD/UPDATE/REMOVE do koszyka sesji, gdy Inventory
+// zmieni miękką rezerwację sesji (strumień InteractiveOrderStock z relays).
+// Całość trwa pod blokadą sesji, więc zamówienie nie zmieni stanu w trakcie rezerwacji.
+func (s *OrderServer) applyCartAction(ctx context.Context, req *invpb.OrderItemRequest, relays *stockRelays) (*invpb.OrderItemResponse, error) {
+	if req.SessionId == "" {
+		return &invpb.OrderItemResponse{ProductId: req.ProductId, Message: "Missing session_id"}, nil
+	}
+	unlock := s.locks.lock(req.SessionId)
+	defer unlock()
+
+	now := time.Now()
+	s.mu.Lock()
+	c, ok := s.sessions[req.SessionId]
+	if !ok {
+		c = newCart(now)
+	}
+	c.lastActive = now
+	// Koszyk można zmieniać tylko, dopóki zamówienie jest szkicem
+	err := s.checkEditable(c)
+	var target int32
+	if err == nil {
+		target, err = c.target(req)
+	}
+	_, inCart := c.items[req.ProductId]
+	s.mu.Unlock()
+	if err != nil {
+		slog.WarnContext(ctx, "cart action rejected",
+			slog.String("rpc.method", "BuildOrder"),
+			slog.String("session_id", req.SessionId),
+			slog.String("product_id", req.ProductId),
+			slog.Any("error", err),
+		)
+		return &invpb.OrderItemResponse{ProductId: req.ProductId, Message: err.Error()}, nil
+	}
+
+	relay, err := relays.get(req.SessionId)
+	if err != nil {
+		return nil, err
+	}
+	// ADD pozycji, która jest już w koszyku, idzie do Inventory jako UPDATE do docelowej
+	// ilości: gdy rezerwacja wygasła, Inventory zarezerwowałoby tylko dodawaną część
+	invReq := req
+	if req.Action == invpb.OrderItemRequest_ADD && inCart {
+		invReq = &invpb.OrderItemRequest{
+			SessionId:         req.SessionId,
+			ProductId:         req.ProductId,
+			RequestedQuantity: target,
+			Action:            invpb.OrderItemRequest_UPDATE,
+		}
+	}
+	invResp, err := relay.exchange(ctx, invReq)
+	if err != nil {
+		return nil, err
+	}
+	slog.DebugContext(ctx, "inventory reservation checked",
+		slog.String("rpc.method", "BuildOrder"),
+		slog.String("product_id", req.ProductId),
+		slog.Bool("available", invResp.Available),
+		slog.Int("free_quantity", int(invResp.AvailableQuantity)),
+		slog.String("message", invResp.Message),
+	)
+	// Rezerwacja pozycji wygasła w Inventory (TTL rezerwacji) – koszyk trzeba uzgodnić
+	expired := !invResp.Available && invResp.NotHeld
+	if expired {
+		slog.WarnContext(ctx, "reservation expired, reconciling cart",
+			slog.String("rpc.method", "BuildOrder"),
+			slog.String("session_id", req.SessionId),
+			slog.String("product_id", req.ProductId),
+			slog.String("action", req.Action.String()),
+		)
+		if invResp, err = reconcileExpiredHold(ctx, relay, invReq, invResp); err != nil {
+			return nil, err
+		}
+	}
+	if !invResp.Available {
+		// Odmowa Inventory (brak produktu lub towaru) – koszyk bez zmian,
+		// chyba że pozycja nie jest już zarezerwowana: wtedy znika z koszyka
+		msg := invResp.Message
+		if expired {
+			if err := s.dropCartItem(req.SessionId, c, req.ProductId); err != nil {
+				return nil, rpcerrors.Internal(fmt.Errorf("save order: %w", err))
 			}
-			log.Printf(
-				"[Order][FinalizeOrder] insufficient stock for product_id=%s available=%d requested=%d",
-				item.ProductId, availableQty, item.Quantity,
-			)
-            res.Reserved = false
-            res.Message = "Insufficient stock"
-            okAll = false
-        } else {
-			log.Printf(
-				"[Order][FinalizeOrder] reserving stock for product_id=%s quantity=%d",
-				item.ProductId, item.Quantity,
-			)
-            _, err := s.inventory.AdjustStock(ctx, &invpb.StockAdjustment{
-                ProductId:      item.ProductId,
-                QuantityChange: -item.Quantity,
-            })
-            if err != nil {
-				log.Printf("[Order][FinalizeOrder] AdjustStock error: %v", err)
-                res.Reserved = false
-                res.Message = "Reservation failed"
-                okAll = false
-            } else {
-				log.Printf("[Order][FinalizeOrder] Reserved product_id=%s", item.ProductId)
-                res.Reserved = true
-                res.Message = "Reserved"
-            }
-        }
-        results = append(results, res)
-    }
-
-    log.Printf("[Order][FinalizeOrder] clearing session_id=%s", req.SessionId)
-    s.mu.Lock()
-    delete(s.sessions, req.SessionId)
-    s.mu.Unlock()
-
-    msg := "Order finalized"
-    if !okAll {
-		log.Printf("[Order][FinalizeOrder] partial failure")
-        msg = "One or more items failed"
+			msg = "Reservation expired and item removed: " + msg
+		}
+		return &invpb.OrderItemResponse{
+			ProductId:         req.ProductId,
+			AvailableQuantity: invResp.AvailableQuantity,
+			Message:           msg,
+		}, nil
+	}
+	resp := &invpb.OrderItemResponse{ProductId: req.ProductId, Available: true, AvailableQuantity: invResp.AvailableQuantity}
+
+	// Blokada sesji wyklucza potwierdzenie lub anulowanie zamówienia w trakcie
+	// rezerwacji w Inventory, więc sprawdzenie checkEditable nadal obowiązuje
+	s.mu.Lock()
+	if _, ok := s.sessions[req.SessionId]; !ok {
+		s.sessions[req.SessionId] = c
+	}
+	c.lastActive = time.Now()
+	c.set(req.ProductId, target)
+	_, err = s.saveDraft(req.SessionId, c, c.orderItems())
+	s.mu.Unlock()
+	if err != nil {
+		return nil, rpcerrors.Internal(fmt.Errorf("save order: %w", err))
+	}
+
+	switch req.Action {
+	case invpb.OrderItemRequest_ADD:
+		resp.Message = "Item added"
+	case invpb.OrderItemRequest_UPDATE:
+		resp.Message = "Item updated"
+	case invpb.OrderItemRequest_REMOVE:
+		resp.Message = "Item removed"
+	}
+	slog.InfoContext(ctx, "cart updated",
+		slog.String("rpc.method", "BuildOrder"),
+		slog.String("session_id", req.SessionId),
+		slog.String("product_id", req.ProductId),
+		slog.Int("quantity", int(target)),
+	)
+	return resp, nil
+}
+
+func (s *OrderServer) FinalizeOrder(ctx context.Context, req *orderpb.FinalizeOrderRequest) (*orderpb.FinalizeOrderResponse, error) {
+	ctx = withSession(ctx, req.SessionId)
+	if err := validateFinalizeRequest(req); err != nil {
+		return nil, err
+	}
+	// Równoległe FinalizeOrder czeka tutaj i nie znajdzie już sesji
+	unlock := s.locks.lock(req.SessionId)
+	defer unlock()
+	o, err := s.sessionOrder(req)
+	if err != nil {
+		return nil, err
+	}
+	slog.InfoContext(ctx, "finalizing order",
+		slog.String("rpc.method", "FinalizeOrder"),
+		slog.String("session_id", req.SessionId),
+		slog.String("order_id", o.OrderId),
+		slog.String("state", o.State.String()),
+		slog.Int("items", len(o.Items)),
+		slog.String("policy", req.Policy.String()),
+	)
+
+	var (
+		results []*orderpb.ItemResult
+		okAll   bool
+	)
+	if o.State == orderpb.OrderState_RESERVED {
+		// Towar zdjęty już przez ConfirmOrderStock – wystarczy zamknąć zamówienie
+		results, okAll, err = s.completeReserved(o)
 	} else {
-		log.Printf("[Order][FinalizeOrder] finalize success")
+		results, okAll, err = s.deductStock(ctx, "FinalizeOrder", o, req.Policy,
+			func(batch *invpb.StockBatchResponse) orderpb.OrderState {
+				switch {
+				case batch.Success:
+					return orderpb.OrderState_CONFIRMED
+				case !allOrNothing(req.Policy) && anyApplied(batch):
+					return orderpb.OrderState_PARTIALLY_FULFILLED
+				default:
+					return orderpb.OrderState_FAILED
+				}
+			})
+	}
+	if err != nil {
+		slog.WarnContext(ctx, "inventory call failed",
+			slog.String("rpc.method", "FinalizeOrder"),
+			slog.Any("error", err),
+		)
+		return nil, err
 	}
+	// Rezerwacje, których nie zużyło ApplyStockBatch (zamówienie FAILED, pozycje niezdjęte
+	// przy ACCEPT_PARTIAL albo spoza pozycji podanych w żądaniu), nie są już potrzebne
+	s.releaseLeftovers(ctx, "FinalizeOrder", req.SessionId)
 
-    return &orderpb.FinalizeOrderResponse{
-        Success:     okAll,
-        Message:     msg,
-        ItemResults: results,
-    }, nil
+	s.mu.Lock()
+	delete(s.sessions, req.SessionId)
+	s.mu.Unlock()
+
+	var msg, outcome string
+	switch o.State {
+	case orderpb.OrderState_CONFIRMED:
+		msg, outcome = "Order finalized", outcomeFinalized
+		slog.InfoContext(ctx, "order finalized",
+			slog.String("rpc.method", "FinalizeOrder"),
+			slog.String("session_id", req.SessionId),
+			slog.String("order_id", o.OrderId),
+		)
+	case orderpb.OrderState_PARTIALLY_FULFILLED:
+		msg, outcome = "One or more items failed", outcomePartiallyFailed
+	default:
+		msg, outcome = "Order rejected, no stock deducted", outcomeRejected
+	}
+	if !okAll {
+		slog.WarnContext(ctx, "order not fulfilled",
+			slog.String("rpc.method", "FinalizeOrder"),
+			slog.String("session_id", req.SessionId),
+			slog.String("order_id", o.OrderId),
+			slog.String("state", o.State.String()),
+			slog.String("policy", req.Policy.String()),
+		)
+	}
+
+	s.recordOutcome(ctx, outcome)
+
+	return &orderpb.FinalizeOrderResponse{
+		Success:     okAll,
+		Message:     msg,
+		ItemResults: results,
+		OrderId:     o.OrderId,
+		State:       o.State,
+	}, nil
 }
 
 func (s *OrderServer) ConfirmOrderStock(ctx context.Context, req *orderpb.FinalizeOrderRequest) (*invpb.OperationStatus, error) {
-    ctx, end := s.instrument(ctx, "ConfirmOrderStock")
-    defer end()
-
-	log.Printf("[Order][ConfirmOrderStock] session_id=%s", req.SessionId)
-	for _, item := range req.GetItems() {
-		log.Printf(
-			"[Order][ConfirmOrderStock] adjusting stock for product_id=%s quantity=%d",
-			item.ProductId, item.Quantity,
+	ctx = withSession(ctx, req.SessionId)
+	if err := validateFinalizeRequest(req); err != nil {
+		return nil, err
+	}
+	unlock := s.locks.lock(req.SessionId)
+	defer unlock()
+	o, err := s.sessionOrder(req)
+	if err != nil {
+		return nil, err
+	}
+	if o.State == orderpb.OrderState_RESERVED {
+		return &invpb.OperationStatus{Success: true, Message: "Stock already confirmed"}, nil
+	}
+	slog.InfoContext(ctx, "confirming order stock",
+		slog.String("rpc.method", "ConfirmOrderStock"),
+		slog.String("session_id", req.SessionId),
+		slog.String("order_id", o.OrderId),
+		slog.String("policy", req.Policy.String()),
+	)
+	// Zamówienie przechodzi w RESERVED, jeśli cokolwiek zdjęto z magazynu; inaczej zostaje szkicem
+	results, okAll, err := s.deductStock(ctx, "ConfirmOrderStock", o, req.Policy,
+		func(batch *invpb.StockBatchResponse) orderpb.OrderState {
+			if batch.Success || (!allOrNothing(req.Policy) && anyApplied(batch)) {
+				return orderpb.OrderState_RESERVED
+			}
+			return orderpb.OrderState_DRAFT
+		})
+	if err != nil {
+		slog.WarnContext(ctx, "inventory call failed",
+			slog.String("rpc.method", "ConfirmOrderStock"),
+			slog.Any("error", err),
 		)
-        _, err := s.inventory.AdjustStock(ctx, &invpb.StockAdjustment{
-            ProductId:      item.ProductId,
-            QuantityChange: -item.Quantity,
-        })
-        if err != nil {
-			log.Printf("[Order][ConfirmOrderStock] AdjustStock error: %v", err)
-            return &invpb.OperationStatus{Success: false, Message: "Stock error"}, nil
-        }
-        log.Printf("[Order][ConfirmOrderStock] Stock adjusted for product_id=%s", item.ProductId)
-    }
+		return nil, err
+	}
+	if !okAll {
+		for _, r := range results {
+			if !r.Reserved {
+				slog.WarnContext(ctx, "item not confirmed",
+					slog.String("rpc.method", "ConfirmOrderStock"),
+					slog.String("product_id", r.ProductId),
+					slog.String("reason", r.Message),
+				)
+			}
+		}
+		if allOrNothing(req.Policy) {
+			return nil, rpcerrors.FailedPrecondition("stock error, no stock deducted", stockViolations(results)...)
+		}
+		return &invpb.OperationStatus{Success: false, Message: "Stock partially confirmed"}, nil
+	}
+
+	slog.InfoContext(ctx, "order stock confirmed",
+		slog.String("rpc.method", "ConfirmOrderStock"),
+		slog.String("session_id", req.SessionId),
+		slog.String("order_id", o.OrderId),
+	)
+	return &invpb.OperationStatus{Success: true, Message: "Stock confirmed"}, nil
+}
+
+// reconcileExpiredHold obsługuje odmowę Inventory dla pozycji, której rezerwacja już
+// wygasła: REMOVE nie ma czego zwalniać, a UPDATE (także ADD pozycji z koszyka)
+// rezerwuje całą docelową ilość od nowa akcją ADD.
+// Zwraca odpowiedź, według której koszyk ma zostać zmieniony
+func reconcileExpiredHold(ctx context.Context, relay *stockRelay, req *invpb.OrderItemRequest, rejected *invpb.OrderItemResponse) (*invpb.OrderItemResponse, error) {
+	switch req.Action {
+	case invpb.OrderItemRequest_REMOVE:
+		return &invpb.OrderItemResponse{ProductId: req.ProductId, Available: true, AvailableQuantity: rejected.AvailableQuantity}, nil
+	case invpb.OrderItemRequest_UPDATE:
+		return relay.exchange(ctx, &invpb.OrderItemRequest{
+			SessionId:         req.SessionId,
+			ProductId:         req.ProductId,
+			RequestedQuantity: req.RequestedQuantity,
+			Action:            invpb.OrderItemRequest_ADD,
+		})
+	default:
+		return rejected, nil
+	}
+}
+
+// dropCartItem usuwa z koszyka pozycję bez rezerwacji w Inventory i zapisuje szkic
+func (s *OrderServer) dropCartItem(sessionID string, c *cart, productID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c.set(productID, 0)
+	_, err := s.saveDraft(sessionID, c, c.orderItems())
+	return err
+}
 
-	log.Printf("[Order][ConfirmOrderStock] confirm success")
-    return &invpb.OperationStatus{Success: true, Message: "Stock confirmed"}, nil
+// sessionOrder zwraca zamówienie sesji (DRAFT albo RESERVED) odczytane pod blokadą
+// sesji, którą trzyma wywołujący. Pozycje podane wprost w żądaniu zastępują pozycje
+// szkicu; przy pustej liście używany jest koszyk zbudowany przez BuildOrder
+func (s *OrderServer) sessionOrder(req *orderpb.FinalizeOrderRequest) (*orderpb.Order, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	c, ok := s.sessions[req.SessionId]
+	if ok {
+		c.lastActive = now
+	}
+	if len(req.Items) > 0 {
+		if !ok {
+			c = newCart(now)
+		}
+		if err := s.checkEditable(c); err != nil {
+			return nil, rpcerrors.FailedPrecondition(err.Error(),
+				rpcerrors.Violation("STATE", resourceOrder+"/"+c.orderID, "items can only be replaced while the order is a draft"))
+		}
+		o, err := s.saveDraft(req.SessionId, c, req.Items)
+		if err != nil {
+			return nil, rpcerrors.Internal(fmt.Errorf("save order: %w", err))
+		}
+		s.sessions[req.SessionId] = c
+		return o, nil
+	}
+
+	if !ok || c.orderID == "" {
+		return nil, rpcerrors.NotFound(resourceSession, req.SessionId)
+	}
+	o, err := s.orders.Get(c.orderID)
+	if err != nil {
+		return nil, orderError(c.orderID, err)
+	}
+	if len(o.Items) == 0 {
+		return nil, rpcerrors.NotFound(resourceSession, req.SessionId)
+	}
+	// Zamówienie mogło zostać zamknięte albo anulowane, zanim wzięliśmy blokadę
+	if o.State != orderpb.OrderState_DRAFT && o.State != orderpb.OrderState_RESERVED {
+		return nil, rpcerrors.FailedPrecondition("order is no longer open",
+			rpcerrors.Violation("STATE", resourceOrder+"/"+o.OrderId, "order is "+o.State.String()))
+	}
+	return o, nil
 }
 
+// checkEditable sprawdza, czy zamówienie koszyka jest jeszcze szkicem;
+// wywołujący trzyma s.mu
+func (s *OrderServer) checkEditable(c *cart) error {
+	if c.orderID == "" {
+		return nil
+	}
+	o, err := s.orders.Get(c.orderID)
+	if err != nil {
+		return err
+	}
+	if o.State != orderpb.OrderState_DRAFT {
+		return fmt.Errorf("%w: %s", errOrderNotEditable, o.State)
+	}
+	return nil
+}
+
+// saveDraft zapisuje items jako pozycje szkicu zamówienia koszyka, tworząc zamówienie
+// przy pierwszej zmianie; wywołujący trzyma s.mu
+func (s *OrderServer) saveDraft(sessionID string, c *cart, items []*orderpb.OrderItem) (*orderpb.Order, error) {
+	now := time.Now()
+	var o *orderpb.Order
+	if c.orderID == "" {
+		o = newOrder(sessionID, now)
+	} else {
+		var err error
+		if o, err = s.orders.Get(c.orderID); err != nil {
+			return nil, err
+		}
+		o.UpdatedAt = timestamppb.New(now)
+	}
+	o.Items = orderLines(items)
+	if err := s.orders.Put(o); err != nil {
+		return nil, err
+	}
+	c.orderID = o.OrderId
+	return o, nil
+}
+
+// putOrder zapisuje zamówienie i – jeśli zmienił się jego stan – rozgłasza przejście z prev
+func (s *OrderServer) putOrder(o *orderpb.Order, prev orderpb.OrderState) error {
+	if err := s.orders.Put(o); err != nil {
+		return err
+	}
+	if o.State != prev {
+		s.bus.publish(o, prev)
+	}
+	return nil
+}
+
+// completeReserved zamyka zamówienie, którego towar zdjęto już w ConfirmOrderStock:
+// CONFIRMED, gdy zdjęto wszystkie pozycje, w przeciwnym razie PARTIALLY_FULFILLED
+func (s *OrderServer) completeReserved(o *orderpb.Order) ([]*orderpb.ItemResult, bool, error) {
+	okAll := allReserved(o)
+	to := orderpb.OrderState_CONFIRMED
+	if !okAll {
+		to = orderpb.OrderState_PARTIALLY_FULFILLED
+	}
+	prev := o.State
+	if err := transition(o, to, time.Now()); err != nil {
+		return nil, false, rpcerrors.Internal(err)
+	}
+	if err := s.putOrder(o, prev); err != nil {
+		return nil, false, rpcerrors.Internal(fmt.Errorf("save order: %w", err))
+	}
+
+	results := make([]*orderpb.ItemResult, len(o.Items))
+	for i, line := range o.Items {
+		r := &orderpb.ItemResult{ProductId: line.ProductId, Reserved: line.Reserved, Message: "Reserved"}
+		if !line.Reserved {
+			r.Message = "Not reserved"
+		}
+		results[i] = r
+	}
+	return results, okAll, nil
+}
+
+// deductStock zdejmuje towar pozycji zamówienia jednym atomowym wywołaniem
+// Inventory.ApplyStockBatch (rezerwacje sesji są zużywane w pierwszej kolejności),
+// a następnie zapisuje zamówienie w stanie wyznaczonym przez next. Gdy zapis się
+// nie powiedzie, zdjęte pozycje wracają do magazynu.
+// Błąd zwracany jest tylko wtedy, gdy nie powiodło się samo wywołanie Inventory lub zapis.
+func (s *OrderServer) deductStock(
+	ctx context.Context,
+	name string,
+	o *orderpb.Order,
+	policy orderpb.FinalizeOrderRequest_FulfillmentPolicy,
+	next func(batch *invpb.StockBatchResponse) orderpb.OrderState,
+) ([]*orderpb.ItemResult, bool, error) {
+	batchReq := &invpb.StockBatchRequest{
+		SessionId:    o.SessionId,
+		AllOrNothing: allOrNothing(policy),
+		Reason:       "order " + o.OrderId,
+	}
+	for _, line := range o.Items {
+		batchReq.Items = append(batchReq.Items, &invpb.StockBatchItem{
+			ProductId: line.ProductId,
+			Quantity:  line.Quantity,
+		})
+	}
+
+	batch, err := s.inventory.ApplyStockBatch(ctx, batchReq)
+	if err != nil {
+		return nil, false, err
+	}
+	slog.InfoContext(ctx, "stock batch applied",
+		slog.String("rpc.method", name),
+		slog.Bool("success", batch.Success),
+		slog.String("message", batch.Message),
+	)
+
+	if err := s.saveDeducted(o, batch, next(batch)); err != nil {
+		// Zamówienie nie odnotowało zdjęcia towaru – oddajemy go, nawet jeśli klient już zrezygnował
+		if rerr := s.restock(context.WithoutCancel(ctx), o, batch); rerr != nil {
+			slog.ErrorContext(ctx, "restock after failed save failed",
+				slog.String("rpc.method", name),
+				slog.String("order_id", o.OrderId),
+				slog.Any("error", rerr),
+			)
+		}
+		return nil, false, rpcerrors.Internal(fmt.Errorf("save order: %w", err))
+	}
+
+	results := make([]*orderpb.ItemResult, len(o.Items))
+	for i, line := range o.Items {
+		r := &orderpb.ItemResult{ProductId: line.ProductId}
+		results[i] = r
+		if br := batch.Results[i]; br.Applied {
+			r.Reserved, r.Message = true, "Reserved"
+		} else {
+			r.Message = br.Message
+		}
+	}
+	return results, batch.Success, nil
+}
+
+// saveDeducted zaznacza pozycje zdjęte przez batch i zapisuje zamówienie w stanie to
+func (s *OrderServer) saveDeducted(o *orderpb.Order, batch *invpb.StockBatchResponse, to orderpb.OrderState) error {
+	now, prev := time.Now(), o.State
+	for i, line := range o.Items {
+		line.Reserved = batch.Results[i].Applied
+	}
+	if to != o.State {
+		if err := transition(o, to, now); err != nil {
+			return err
+		}
+	} else {
+		o.UpdatedAt = timestamppb.New(now)
+	}
+	return s.putOrder(o, prev)
+}
+
+// anyApplied zwraca true, gdy Inventory zdjęło z magazynu co najmniej jedną pozycję
+func anyApplied(batch *invpb.StockBatchResponse) bool {
+	for _, r := range batch.GetResults() {
+		if r.Applied {
+			return true
+		}
+	}
+	return false
+}
+
+// restock oddaje do magazynu pozycje zamówienia zdjęte przez ApplyStockBatch
+func (s *OrderServer) restock(ctx context.Context, o *orderpb.Order, batch *invpb.StockBatchResponse) error {
+	// Kompensacja ma własny span; wywołania AdjustStock są jego dziećmi
+	ctx, span := otel.Tracer("order-service").Start(ctx, "compensate ApplyStockBatch",
+		trace.WithAttributes(attribute.String("order_id", o.OrderId)))
+	defer span.End()
+
+	var (
+		errs      []error
+		restocked int
+	)
+	for i, r := range batch.GetResults() {
+		if !r.Applied {
+			continue
+		}
+		if err := s.adjustStock(ctx, r.ProductId, o.Items[i].Quantity, "compensation for order "+o.OrderId); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", r.ProductId, err))
+			continue
+		}
+		restocked++
+	}
+	err := errors.Join(errs...)
+	outcome := "compensated"
+	if err != nil {
+		outcome = "failed"
+		span.RecordError(err)
+		span.SetStatus(otelcodes.Error, err.Error())
+	}
+	span.SetAttributes(
+		attribute.String("compensation.outcome", outcome),
+		attribute.Int("compensation.restocked_items", restocked),
+	)
+	return err
+}
+
+// adjustStock wywołuje Inventory.AdjustStock, traktując Success=false jako błąd
+func (s *OrderServer) adjustStock(ctx context.Context, productID string, change int32, reason string) error {
+	resp, err := s.inventory.AdjustStock(ctx, &invpb.StockAdjustment{
+		ProductId:      productID,
+		QuantityChange: change,
+		Reason:         reason,
+	})
+	if err != nil {
+		return err
+	}
+	if !resp.Success {
+		return errors.New(resp.Message)
+	}
+	return nil
+}
+
+// withSession oznacza bieżący span identyfikatorem sesji i dodaje go do baggage,
+// dzięki czemu trafia na spany potomne i (przez propagację) do Inventory
+func withSession(ctx context.Context, sessionID string) context.Context {
+	if sessionID == "" {
+		return ctx
+	}
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String(telemetry.BaggageSessionID, sessionID))
+	return telemetry.WithBaggage(ctx, telemetry.BaggageSessionID, sessionID)
+}
+
+func allOrNothing(policy orderpb.FinalizeOrderRequest_FulfillmentPolicy) bool {
+	return policy != orderpb.FinalizeOrderRequest_ACCEPT_PARTIAL
+}
+
+// CancelOrder anuluje zamówienie sesji (albo wskazane przez order_id): zwalnia miękkie
+// rezerwacje sesji w Inventory i oddaje do magazynu towar zdjęty już dla zamówienia.
+// Pozycje, których nie udało się oddać, pozostają oznaczone jako zdjęte, a zamówienie
+// nie zmienia stanu – ponowne wywołanie CancelOrder dokończy anulowanie.
 func (s *OrderServer) CancelOrder(ctx context.Context, req *orderpb.CancelOrderRequest) (*orderpb.CancelOrderResponse, error) {
-    _, end := s.instrument(ctx, "CancelOrder")
-    defer end()
-
-    log.Printf("[Order][CancelOrder] session_id=%s", req.SessionId)
-    s.mu.Lock()
-    defer s.mu.Unlock()
-    if _, ok := s.sessions[req.SessionId]; !ok {
-        log.Printf("[Order][CancelOrder] session_id=%s not found", req.SessionId)
-        return &orderpb.CancelOrderResponse{Released: false, Message: "Session not found"}, nil
-    }
-    delete(s.sessions, req.SessionId)
-    log.Printf("[Order][CancelOrder] cancel success for session_id=%s", req.SessionId)
-    return &orderpb.CancelOrderResponse{Released: true, Message: "Order cancelled and reservations released"}, nil
+	ctx = withSession(ctx, req.SessionId)
+	slog.DebugContext(ctx, "request received",
+		slog.String("rpc.method", "CancelOrder"),
+		slog.String("session_id", req.SessionId),
+		slog.String("order_id", req.OrderId),
+	)
+	if err := validateCancelRequest(req); err != nil {
+		return nil, err
+	}
+
+	// Pierwszy odczyt wskazuje tylko sesję do zablokowania; o tym, co oddać do
+	// magazynu, decyduje stan odczytany ponownie pod blokadą sesji – tak samo
+	// szeregowane są ConfirmOrderStock, FinalizeOrder i wygaszanie sesji
+	_, sessionID, err := s.orderToCancel(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	unlock := s.locks.lock(sessionID)
+	defer unlock()
+
+	o, sessionID, err := s.orderToCancel(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if o != nil && !canTransition(o.State, orderpb.OrderState_CANCELLED) {
+		return nil, rpcerrors.FailedPrecondition("order cannot be cancelled",
+			rpcerrors.Violation("STATE", resourceOrder+"/"+o.OrderId, "order is "+o.State.String()))
+	}
+	ctx = withSession(ctx, sessionID)
+
+	resp, err := s.cancelSession(ctx, "CancelOrder", sessionID, o)
+	if err != nil {
+		return nil, err
+	}
+	if !resp.Released {
+		resp.Message = "One or more items could not be released, retry CancelOrder"
+		return resp, nil
+	}
+	s.recordOutcome(ctx, outcomeCancelled)
+	slog.InfoContext(ctx, "order cancelled",
+		slog.String("rpc.method", "CancelOrder"),
+		slog.String("session_id", sessionID),
+		slog.String("order_id", resp.OrderId),
+		slog.Int("released_items", len(resp.ItemResults)),
+	)
+	resp.Message = "Order cancelled and reservations released"
+	return resp, nil
+}
+
+// cancelSession zwalnia rezerwacje sesji, oddaje towar zamówienia o (może być nil)
+// i – jeśli wszystko się udało – przenosi je do CANCELLED i usuwa sesję.
+// Wywołujący trzyma blokadę sesji (s.locks); method trafia do logów.
+func (s *OrderServer) cancelSession(ctx context.Context, method, sessionID string, o *orderpb.Order) (*orderpb.CancelOrderResponse, error) {
+	var results []*orderpb.ReleaseResult
+	// Rezerwacje sesji należą do jej bieżącego koszyka – anulowanie starego
+	// zamówienia nie może zwolnić rezerwacji nowego koszyka tej samej sesji
+	if s.holdsSessionReservations(sessionID, o) {
+		var err error
+		if results, err = s.releaseReservations(ctx, sessionID); err != nil {
+			slog.WarnContext(ctx, "inventory call failed",
+				slog.String("rpc.method", method),
+				slog.Any("error", err),
+			)
+			return nil, err
+		}
+	}
+	resp := &orderpb.CancelOrderResponse{Released: true}
+	if o != nil {
+		resp.OrderId = o.OrderId
+		results = append(results, s.restockOrder(ctx, method, o)...)
+	}
+	resp.ItemResults = results
+	for _, r := range results {
+		if !r.Released {
+			resp.Released = false
+		}
+	}
+
+	if o != nil {
+		now, prev := time.Now(), o.State
+		if resp.Released {
+			if err := transition(o, orderpb.OrderState_CANCELLED, now); err != nil {
+				return nil, rpcerrors.Internal(err)
+			}
+		} else {
+			o.UpdatedAt = timestamppb.New(now)
+		}
+		if err := s.putOrder(o, prev); err != nil {
+			return nil, rpcerrors.Internal(fmt.Errorf("save order: %w", err))
+		}
+		resp.State = o.State
+	}
+	if !resp.Released {
+		slog.WarnContext(ctx, "order not fully released",
+			slog.String("rpc.method", method),
+			slog.String("session_id", sessionID),
+			slog.String("order_id", resp.OrderId),
+		)
+		return resp, nil
+	}
+
+	s.mu.Lock()
+	if c, ok := s.sessions[sessionID]; ok && c.orderID == resp.OrderId {
+		delete(s.sessions, sessionID)
+	}
+	s.mu.Unlock()
+	return resp, nil
+}
+
+// holdsSessionReservations zwraca true, gdy miękkie rezerwacje sesji należą do
+// zamówienia o: jest ono otwarte albo wciąż jest zamówieniem koszyka sesji
+// (o == nil oznacza koszyk jeszcze bez zamówienia)
+func (s *OrderServer) holdsSessionReservations(sessionID string, o *orderpb.Order) bool {
+	if o == nil || o.State == orderpb.OrderState_DRAFT || o.State == orderpb.OrderState_RESERVED {
+		return true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.sessions[sessionID]
+	return ok && c.orderID == o.OrderId
+}
+
+// orderToCancel wyszukuje anulowane zamówienie i jego sesję; zamówienie jest nil
+// dla sesji, której koszyka nie zapisano jeszcze jako zamówienia
+func (s *OrderServer) orderToCancel(ctx context.Context, req *orderpb.CancelOrderRequest) (*orderpb.Order, string, error) {
+	id := req.OrderId
+	if id == "" {
+		s.mu.Lock()
+		c, ok := s.sessions[req.SessionId]
+		if ok {
+			id = c.orderID
+		}
+		s.mu.Unlock()
+		if !ok {
+			slog.WarnContext(ctx, "session not found",
+				slog.String("rpc.method", "CancelOrder"),
+				slog.String("session_id", req.SessionId),
+			)
+			return nil, "", rpcerrors.NotFound(resourceSession, req.SessionId)
+		}
+		if id == "" {
+			return nil, req.SessionId, nil
+		}
+	}
+
+	o, err := s.orders.Get(id)
+	if err != nil {
+		return nil, "", orderError(id, err)
+	}
+	if req.SessionId != "" && req.SessionId != o.SessionId {
+		return nil, "", rpcerrors.InvalidArgument("order does not belong to the session",
+			rpcerrors.Field("order_id", "must belong to session_id"))
+	}
+	return o, o.SessionId, nil
+}
+
+// releaseReservations zwalnia miękkie rezerwacje sesji w Inventory; brak rezerwacji nie jest błędem
+func (s *OrderServer) releaseReservations(ctx context.Context, sessionID string) ([]*orderpb.ReleaseResult, error) {
+	resp, err := s.inventory.ReleaseReservation(ctx, &invpb.ReservationRequest{SessionId: sessionID})
+	if status.Code(err) == codes.NotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]*orderpb.ReleaseResult, len(resp.Lines))
+	for i, line := range resp.Lines {
+		r := &orderpb.ReleaseResult{ProductId: line.ProductId, Quantity: line.Quantity, Message: line.Message}
+		if line.Applied {
+			r.Released, r.Message = true, "Reservation released"
+		}
+		results[i] = r
+	}
+	return results, nil
+}
+
+// releaseLeftovers zwalnia pozostałe rezerwacje zakończonej sesji; błąd jest tylko
+// logowany – zamówienie jest już zapisane, a rezerwacje i tak wygasną po TTL Inventory
+func (s *OrderServer) releaseLeftovers(ctx context.Context, method, sessionID string) {
+	results, err := s.releaseReservations(context.WithoutCancel(ctx), sessionID)
+	if err != nil {
+		slog.WarnContext(ctx, "releasing leftover reservations failed",
+			slog.String("rpc.method", method),
+			slog.String("session_id", sessionID),
+			slog.Any("error", err),
+		)
+		return
+	}
+	if len(results) > 0 {
+		slog.InfoContext(ctx, "leftover reservations released",
+			slog.String("rpc.method", method),
+			slog.String("session_id", sessionID),
+			slog.Int("items", len(results)),
+		)
+	}
+}
+
+// restockOrder oddaje do magazynu pozycje zamówienia zdjęte przez ApplyStockBatch;
+// oddane pozycje przestają być oznaczone jako zdjęte
+func (s *OrderServer) restockOrder(ctx context.Context, method string, o *orderpb.Order) []*orderpb.ReleaseResult {
+	// Raz rozpoczęte oddawanie towaru musi się wykonać nawet po anulowaniu żądania przez klienta
+	ctx = context.WithoutCancel(ctx)
+	var results []*orderpb.ReleaseResult
+	for _, line := range o.Items {
+		if !line.Reserved {
+			continue
+		}
+		r := &orderpb.ReleaseResult{ProductId: line.ProductId, Quantity: line.Quantity}
+		if err := s.adjustStock(ctx, line.ProductId, line.Quantity, "cancellation of order "+o.OrderId); err != nil {
+			slog.WarnContext(ctx, "restock failed",
+				slog.String("rpc.method", method),
+				slog.String("product_id", line.ProductId),
+				slog.Any("error", err),
+			)
+			r.Message = err.Error()
+		} else {
+			line.Reserved = false
+			r.Released, r.Message = true, "Restocked"
+		}
+		results = append(results, r)
+	}
+	return results
+}
+
+func (s *OrderServer) GetOrder(ctx context.Context, req *orderpb.GetOrderRequest) (*orderpb.Order, error) {
+	slog.DebugContext(ctx, "request received",
+		slog.String("rpc.method", "GetOrder"),
+		slog.String("order_id", req.OrderId),
+	)
+	if err := requireOrderID(req.OrderId); err != nil {
+		return nil, err
+	}
+	o, err := s.orders.Get(req.OrderId)
+	if err != nil {
+		return nil, orderError(req.OrderId, err)
+	}
+	return o, nil
+}
+
+// ListOrders zwraca stronę zamówień spełniających filtry, od najstarszego
+func (s *OrderServer) ListOrders(ctx context.Context, req *orderpb.ListOrdersRequest) (*orderpb.ListOrdersResponse, error) {
+	slog.DebugContext(ctx, "request received",
+		slog.String("rpc.method", "ListOrders"),
+		slog.Any("states", req.States),
+		slog.String("product_id", req.ProductId),
+		slog.Int("page_size", int(req.PageSize)),
+		slog.Bool("continued", req.PageToken != ""),
+	)
+	if err := validateListOrdersRequest(req); err != nil {
+		return nil, err
+	}
+	cursor, _ := decodePageToken(req.PageToken)
+
+	size := pageSize(req.PageSize)
+	orders, more, err := s.orders.Page(cursor, size, func(o *orderpb.Order) bool { return matchesFilter(req, o) })
+	if err != nil {
+		slog.ErrorContext(ctx, "store operation failed",
+			slog.String("rpc.method", "ListOrders"),
+			slog.Any("error", err),
+		)
+		return nil, rpcerrors.Internal(err)
+	}
+
+	resp := &orderpb.ListOrdersResponse{Orders: orders}
+	// Token tylko wtedy, gdy za pełną stroną jest jeszcze pasujące zamówienie
+	if more {
+		resp.NextPageToken = encodePageToken(orders[len(orders)-1])
+	}
+	slog.InfoContext(ctx, "orders listed",
+		slog.String("rpc.method", "ListOrders"),
+		slog.Int("orders", len(resp.Orders)),
+		slog.Bool("more", resp.NextPageToken != ""),
+	)
+	return resp, nil
+}
+
+// WatchOrder wysyła bieżący stan zamówienia, a potem każde jego przejście;
+// strumień kończy się, gdy zamówienie osiągnie stan, z którego nie ma wyjścia
+func (s *OrderServer) WatchOrder(req *orderpb.WatchOrderRequest, stream orderpb.OrderService_WatchOrderServer) error {
+	ctx := stream.Context()
+	slog.DebugContext(ctx, "request received",
+		slog.String("rpc.method", "WatchOrder"),
+		slog.String("order_id", req.OrderId),
+	)
+	if err := requireOrderID(req.OrderId); err != nil {
+		return err
+	}
+
+	// Subskrypcja przed odczytem stanu, żeby nie zgubić przejść w międzyczasie
+	sub, unsubscribe := s.bus.subscribe()
+	defer unsubscribe()
+
+	o, err := s.orders.Get(req.OrderId)
+	if err != nil {
+		return orderError(req.OrderId, err)
+	}
+	if err := stream.Send(&orderpb.OrderEvent{Order: o}); err != nil {
+		return err
+	}
+	last := o.State
+
+	for !isFinal(last) {
+		select {
+		case <-ctx.Done():
+			slog.InfoContext(ctx, "watch canceled by client",
+				slog.String("rpc.method", "WatchOrder"),
+				slog.String("order_id", req.OrderId),
+			)
+			return nil
+		case <-s.closing:
+			slog.InfoContext(ctx, "watch closed for shutdown",
+				slog.String("rpc.method", "WatchOrder"),
+				slog.String("order_id", req.OrderId),
+			)
+			return rpcerrors.Unavailable("order service is shutting down")
+		case <-sub.lagged:
+			slog.InfoContext(ctx, "subscriber lagged, resyncing",
+				slog.String("rpc.method", "WatchOrder"),
+				slog.String("order_id", req.OrderId),
+			)
+			o, err := s.orders.Get(req.OrderId)
+			if err != nil {
+				return orderError(req.OrderId, err)
+			}
+			if o.State == last {
+				continue
+			}
+			if err := stream.Send(&orderpb.OrderEvent{Order: o, PreviousState: last}); err != nil {
+				return err
+			}
+			last = o.State
+		case ev := <-sub.events:
+			if ev.Order.OrderId != req.OrderId || ev.Order.State == last {
+				continue
+			}
+			if err := stream.Send(&orderpb.OrderEvent{Order: ev.Order, PreviousState: ev.Previous}); err != nil {
+				return err
+			}
+			last = ev.Order.State
+		}
+	}
+	slog.InfoContext(ctx, "stream completed",
+		slog.String("rpc.method", "WatchOrder"),
+		slog.String("order_id", req.OrderId),
+		slog.String("state", last.String()),
+	)
+	return nil
+}
+
+// Shutdown kończy (statusem Unavailable) strumienie WatchOrder zamówień, które jeszcze
+// mogą zmienić stan, tak aby GracefulStop nie czekał na nie do upływu okresu karencji
+func (s *OrderServer) Shutdown() {
+	s.closeOnce.Do(func() { close(s.closing) })
 }
@@ -0,0 +1,529 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	invpb "Service-sharing-environment-project/proto/inventory"
+	orderpb "Service-sharing-environment-project/proto/order"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/metric/noop"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fakeInventory zdejmuje każdą pozycję paczki i liczy wywołania; delay wydłuża
+// wywołania Inventory, żeby równoległe żądania na pewno się na siebie nałożyły
+type fakeInventory struct {
+	invpb.InventoryServiceClient
+	delay time.Duration
+
+	mu        sync.Mutex
+	batches   int
+	restocked map[string]int32
+	releases  int
+	// holds to miękkie rezerwacje InteractiveOrderStock (jedna sesja);
+	// maxHold > 0 ogranicza rezerwację pojedynczego produktu
+	holds   map[string]int32
+	maxHold int32
+}
+
+func (f *fakeInventory) ApplyStockBatch(_ context.Context, req *invpb.StockBatchRequest, _ ...grpc.CallOption) (*invpb.StockBatchResponse, error) {
+	time.Sleep(f.delay)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.batches++
+	resp := &invpb.StockBatchResponse{Success: true}
+	for _, item := range req.Items {
+		resp.Results = append(resp.Results, &invpb.StockBatchItemResult{ProductId: item.ProductId, Applied: true})
+	}
+	return resp, nil
+}
+
+func (f *fakeInventory) AdjustStock(_ context.Context, req *invpb.StockAdjustment, _ ...grpc.CallOption) (*invpb.OperationStatus, error) {
+	time.Sleep(f.delay)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.restocked == nil {
+		f.restocked = make(map[string]int32)
+	}
+	f.restocked[req.ProductId] += req.QuantityChange
+	return &invpb.OperationStatus{Success: true}, nil
+}
+
+func (f *fakeInventory) ReleaseReservation(context.Context, *invpb.ReservationRequest, ...grpc.CallOption) (*invpb.ReservationResult, error) {
+	time.Sleep(f.delay)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.releases++
+	return nil, status.Error(codes.NotFound, "no reservations")
+}
+
+func (f *fakeInventory) InteractiveOrderStock(ctx context.Context, _ ...grpc.CallOption) (invpb.InventoryService_InteractiveOrderStockClient, error) {
+	return &fakeOrderStream{ctx: ctx, inv: f, out: make(chan *invpb.OrderItemResponse, 1)}, nil
+}
+
+// fakeOrderStream rezerwuje jak Inventory: UPDATE i REMOVE wymagają istniejącej rezerwacji
+type fakeOrderStream struct {
+	grpc.ClientStream
+	ctx context.Context
+	inv *fakeInventory
+	out chan *invpb.OrderItemResponse
+}
+
+func (s *fakeOrderStream) Send(req *invpb.OrderItemRequest) error {
+	f := s.inv
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.holds == nil {
+		f.holds = make(map[string]int32)
+	}
+	held := f.holds[req.ProductId]
+	target := req.RequestedQuantity
+	switch req.Action {
+	case invpb.OrderItemRequest_ADD:
+		target += held
+	case invpb.OrderItemRequest_REMOVE:
+		target = 0
+	}
+	resp := &invpb.OrderItemResponse{ProductId: req.ProductId, Available: true}
+	switch {
+	case req.Action != invpb.OrderItemRequest_ADD && held == 0:
+		resp = &invpb.OrderItemResponse{ProductId: req.ProductId, Message: "Item not in session", NotHeld: true}
+	case f.maxHold > 0 && target > f.maxHold:
+		resp = &invpb.OrderItemResponse{ProductId: req.ProductId, AvailableQuantity: f.maxHold, Message: "Insufficient stock"}
+	case target == 0:
+		delete(f.holds, req.ProductId)
+	default:
+		f.holds[req.ProductId] = target
+	}
+	s.out <- resp
+	return nil
+}
+
+func (s *fakeOrderStream) Recv() (*invpb.OrderItemResponse, error) {
+	select {
+	case resp, ok := <-s.out:
+		if !ok {
+			return nil, io.EOF
+		}
+		return resp, nil
+	case <-s.ctx.Done():
+		return nil, status.FromContextError(s.ctx.Err()).Err()
+	}
+}
+
+func (s *fakeOrderStream) CloseSend() error {
+	close(s.out)
+	return nil
+}
+
+func newTestOrderServer(t *testing.T, inv invpb.InventoryServiceClient) *OrderServer {
+	t.Helper()
+	s, err := NewOrderServer(inv, NewMemoryOrderStore(), noop.NewMeterProvider().Meter("test"), time.Hour)
+	if err != nil {
+		t.Fatalf("NewOrderServer: %v", err)
+	}
+	t.Cleanup(s.Close)
+	return s
+}
+
+// draftSession zapisuje szkic zamówienia sesji tak, jak robi to BuildOrder
+func draftSession(t *testing.T, s *OrderServer, sessionID string, items ...*orderpb.OrderItem) *orderpb.Order {
+	t.Helper()
+	c := newCart(time.Now())
+	for _, item := range items {
+		c.set(item.ProductId, item.Quantity)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, err := s.saveDraft(sessionID, c, c.orderItems())
+	if err != nil {
+		t.Fatalf("saveDraft: %v", err)
+	}
+	s.sessions[sessionID] = c
+	return o
+}
+
+func TestConcurrentFinalizeDeductsOnce(t *testing.T) {
+	inv := &fakeInventory{delay: 20 * time.Millisecond}
+	s := newTestOrderServer(t, inv)
+	o := draftSession(t, s, "S1", &orderpb.OrderItem{ProductId: "P001", Quantity: 2})
+
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+	for i := range errs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, errs[i] = s.FinalizeOrder(context.Background(), &orderpb.FinalizeOrderRequest{SessionId: "S1"})
+		}()
+	}
+	wg.Wait()
+
+	if inv.batches != 1 {
+		t.Errorf("ApplyStockBatch called %d times, want 1", inv.batches)
+	}
+	var finalized int
+	for _, err := range errs {
+		switch status.Code(err) {
+		case codes.OK:
+			finalized++
+		case codes.NotFound:
+		default:
+			t.Errorf("FinalizeOrder: unexpected error %v", err)
+		}
+	}
+	if finalized != 1 {
+		t.Errorf("%d FinalizeOrder calls succeeded, want 1", finalized)
+	}
+	got, err := s.orders.Get(o.OrderId)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if got.State != orderpb.OrderState_CONFIRMED {
+		t.Errorf("order state %s, want CONFIRMED", got.State)
+	}
+}
+
+func TestConfirmThenFinalizeDoesNotDeductTwice(t *testing.T) {
+	inv := &fakeInventory{}
+	s := newTestOrderServer(t, inv)
+	o := draftSession(t, s, "S1", &orderpb.OrderItem{ProductId: "P001", Quantity: 1})
+	ctx := context.Background()
+	req := &orderpb.FinalizeOrderRequest{SessionId: "S1"}
+
+	if _, err := s.ConfirmOrderStock(ctx, req); err != nil {
+		t.Fatalf("ConfirmOrderStock: %v", err)
+	}
+	resp, err := s.FinalizeOrder(ctx, req)
+	if err != nil {
+		t.Fatalf("FinalizeOrder: %v", err)
+	}
+	if resp.State != orderpb.OrderState_CONFIRMED || resp.OrderId != o.OrderId {
+		t.Errorf("FinalizeOrder = %s %s, want %s CONFIRMED", resp.OrderId, resp.State, o.OrderId)
+	}
+	if inv.batches != 1 {
+		t.Errorf("ApplyStockBatch called %d times, want 1", inv.batches)
+	}
+}
+
+func TestRestartRestoresAndExpiresOpenSessions(t *testing.T) {
+	orders := NewMemoryOrderStore()
+	stale := newOrder("S1", time.Now().Add(-2*time.Hour))
+	stale.State = orderpb.OrderState_RESERVED
+	stale.Items = []*orderpb.OrderLine{{ProductId: "P001", Quantity: 3, Reserved: true}}
+	done := newOrder("S2", time.Now().Add(-2*time.Hour))
+	done.State = orderpb.OrderState_CONFIRMED
+	for _, o := range []*orderpb.Order{stale, done} {
+		if err := orders.Put(o); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+	}
+
+	inv := &fakeInventory{}
+	s, err := NewOrderServer(inv, orders, noop.NewMeterProvider().Meter("test"), time.Hour)
+	if err != nil {
+		t.Fatalf("NewOrderServer: %v", err)
+	}
+	defer s.Close()
+
+	s.mu.Lock()
+	c, ok := s.sessions["S1"]
+	_, restoredDone := s.sessions["S2"]
+	s.mu.Unlock()
+	if !ok || c.orderID != stale.OrderId || c.items["P001"] != 3 {
+		t.Fatalf("session S1 not restored from order %s", stale.OrderId)
+	}
+	if restoredDone {
+		t.Error("session of a CONFIRMED order restored")
+	}
+
+	// Odtworzona sesja jest bezczynna od UpdatedAt, więc wygasa i oddaje towar
+	s.expireSessions(time.Now())
+	got, err := orders.Get(stale.OrderId)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if got.State != orderpb.OrderState_CANCELLED {
+		t.Errorf("order state %s, want CANCELLED", got.State)
+	}
+	if inv.restocked["P001"] != 3 {
+		t.Errorf("restocked %d of P001, want 3", inv.restocked["P001"])
+	}
+}
+
+func TestCancelRacingFinalizeReturnsAllStock(t *testing.T) {
+	for i := range 10 {
+		inv := &fakeInventory{delay: 5 * time.Millisecond}
+		s := newTestOrderServer(t, inv)
+		o := draftSession(t, s, "S1", &orderpb.OrderItem{ProductId: "P001", Quantity: 2})
+		ctx := context.Background()
+
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			s.FinalizeOrder(ctx, &orderpb.FinalizeOrderRequest{SessionId: "S1"})
+		}()
+		var cancelErr error
+		go func() {
+			defer wg.Done()
+			_, cancelErr = s.CancelOrder(ctx, &orderpb.CancelOrderRequest{OrderId: o.OrderId})
+		}()
+		wg.Wait()
+		if cancelErr != nil {
+			t.Fatalf("run %d: CancelOrder: %v", i, cancelErr)
+		}
+
+		got, err := s.orders.Get(o.OrderId)
+		if err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+		if got.State != orderpb.OrderState_CANCELLED {
+			t.Errorf("run %d: order state %s, want CANCELLED", i, got.State)
+		}
+		// Anulowanie musi oddać dokładnie to, co zdjęła finalizacja
+		if deducted := int32(inv.batches) * 2; inv.restocked["P001"] != deducted {
+			t.Errorf("run %d: restocked %d of P001, deducted %d", i, inv.restocked["P001"], deducted)
+		}
+	}
+}
+
+func TestCartReconcilesExpiredHolds(t *testing.T) {
+	inv := &fakeInventory{}
+	s := newTestOrderServer(t, inv)
+	ctx := context.Background()
+	relays := newStockRelays(ctx, inv, func(*invpb.OrderItemResponse) error { return nil })
+	defer relays.closeSend()
+
+	act := func(action invpb.OrderItemRequest_ActionType, productID string, qty int32) *invpb.OrderItemResponse {
+		t.Helper()
+		resp, err := s.applyCartAction(ctx, &invpb.OrderItemRequest{SessionId: "S1", ProductId: productID, RequestedQuantity: qty, Action: action}, relays)
+		if err != nil {
+			t.Fatalf("%s %s: %v", action, productID, err)
+		}
+		return resp
+	}
+	expireHolds := func() {
+		inv.mu.Lock()
+		inv.holds = nil
+		inv.mu.Unlock()
+	}
+	wantItems := func(want map[string]int32) {
+		t.Helper()
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		c := s.sessions["S1"]
+		o, err := s.orders.Get(c.orderID)
+		if err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+		if len(o.Items) != len(want) || len(c.items) != len(want) {
+			t.Fatalf("cart %v, order %v, want %v", c.items, o.Items, want)
+		}
+		for _, line := range o.Items {
+			if want[line.ProductId] != line.Quantity || c.items[line.ProductId] != line.Quantity {
+				t.Errorf("%s: order %d, cart %d, want %d", line.ProductId, line.Quantity, c.items[line.ProductId], want[line.ProductId])
+			}
+		}
+	}
+
+	act(invpb.OrderItemRequest_ADD, "P001", 2)
+	act(invpb.OrderItemRequest_ADD, "P002", 1)
+	act(invpb.OrderItemRequest_ADD, "P003", 1)
+	expireHolds()
+
+	// UPDATE rezerwuje pozycję od nowa
+	if resp := act(invpb.OrderItemRequest_UPDATE, "P001", 3); !resp.Available {
+		t.Errorf("UPDATE after expiry rejected: %s", resp.Message)
+	}
+	if inv.holds["P001"] != 3 {
+		t.Errorf("P001 held %d, want 3", inv.holds["P001"])
+	}
+	// REMOVE nie ma czego zwalniać, ale pozycja znika z koszyka
+	if resp := act(invpb.OrderItemRequest_REMOVE, "P002", 0); !resp.Available {
+		t.Errorf("REMOVE after expiry rejected: %s", resp.Message)
+	}
+	wantItems(map[string]int32{"P001": 3, "P003": 1})
+
+	// ADD do pozycji z wygasłą rezerwacją rezerwuje całą ilość z koszyka
+	expireHolds()
+	if resp := act(invpb.OrderItemRequest_ADD, "P001", 2); !resp.Available || resp.Message != "Item added" {
+		t.Errorf("ADD after expiry: available %v, %q", resp.Available, resp.Message)
+	}
+	if inv.holds["P001"] != 5 {
+		t.Errorf("P001 held %d after ADD, want 5", inv.holds["P001"])
+	}
+	wantItems(map[string]int32{"P001": 5, "P003": 1})
+
+	// Gdy ponowna rezerwacja się nie uda, pozycja bez rezerwacji też znika
+	expireHolds()
+	inv.maxHold = 1
+	if resp := act(invpb.OrderItemRequest_UPDATE, "P003", 2); resp.Available {
+		t.Error("UPDATE beyond stock after expiry accepted")
+	}
+	wantItems(map[string]int32{"P001": 5})
+}
+
+func TestCancelOldOrderKeepsNewCartReservations(t *testing.T) {
+	inv := &fakeInventory{}
+	s := newTestOrderServer(t, inv)
+	ctx := context.Background()
+	old := draftSession(t, s, "S1", &orderpb.OrderItem{ProductId: "P001", Quantity: 1})
+	if _, err := s.FinalizeOrder(ctx, &orderpb.FinalizeOrderRequest{SessionId: "S1"}); err != nil {
+		t.Fatalf("FinalizeOrder: %v", err)
+	}
+	// Nowy koszyk tej samej sesji
+	current := draftSession(t, s, "S1", &orderpb.OrderItem{ProductId: "P002", Quantity: 1})
+	releases := inv.releases
+
+	resp, err := s.CancelOrder(ctx, &orderpb.CancelOrderRequest{OrderId: old.OrderId})
+	if err != nil {
+		t.Fatalf("CancelOrder: %v", err)
+	}
+	if resp.State != orderpb.OrderState_CANCELLED || inv.restocked["P001"] != 1 {
+		t.Errorf("old order %s, restocked %d of P001; want CANCELLED, 1", resp.State, inv.restocked["P001"])
+	}
+	if inv.releases != releases {
+		t.Errorf("ReleaseReservation called %d times for the old order, want 0", inv.releases-releases)
+	}
+	s.mu.Lock()
+	c, ok := s.sessions["S1"]
+	s.mu.Unlock()
+	if !ok || c.orderID != current.OrderId {
+		t.Error("cancelling the old order ended the new cart's session")
+	}
+}
+
+// failingSaveStore odrzuca zapis zamówienia wychodzącego ze szkicu
+type failingSaveStore struct {
+	*MemoryOrderStore
+}
+
+func (s failingSaveStore) Put(o *orderpb.Order) error {
+	if o.State != orderpb.OrderState_DRAFT {
+		return errors.New("disk full")
+	}
+	return s.MemoryOrderStore.Put(o)
+}
+
+func TestFailedSaveCompensatesInTrace(t *testing.T) {
+	rec := tracetest.NewSpanRecorder()
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec)))
+	t.Cleanup(func() { otel.SetTracerProvider(prev) })
+
+	inv := &fakeInventory{}
+	s, err := NewOrderServer(inv, failingSaveStore{NewMemoryOrderStore()}, noop.NewMeterProvider().Meter("test"), time.Hour)
+	if err != nil {
+		t.Fatalf("NewOrderServer: %v", err)
+	}
+	t.Cleanup(s.Close)
+	draftSession(t, s, "S1", &orderpb.OrderItem{ProductId: "P001", Quantity: 2})
+
+	_, err = s.FinalizeOrder(context.Background(), &orderpb.FinalizeOrderRequest{SessionId: "S1"})
+	if status.Code(err) != codes.Internal {
+		t.Fatalf("FinalizeOrder error %v, want Internal", err)
+	}
+	if inv.restocked["P001"] != 2 {
+		t.Errorf("restocked %d of P001, want 2", inv.restocked["P001"])
+	}
+
+	for _, span := range rec.Ended() {
+		if span.Name() != "compensate ApplyStockBatch" {
+			continue
+		}
+		for _, kv := range span.Attributes() {
+			if kv.Key == "compensation.outcome" && kv.Value.AsString() != "compensated" {
+				t.Errorf("compensation.outcome = %s, want compensated", kv.Value.AsString())
+			}
+		}
+		return
+	}
+	t.Error("no compensate ApplyStockBatch span")
+}
+
+// lateAlertInventory przysyła alert niskiego stanu akurat wtedy, gdy BuildOrder zamyka strumień
+type lateAlertInventory struct {
+	*fakeInventory
+}
+
+func (f lateAlertInventory) InteractiveOrderStock(ctx context.Context, _ ...grpc.CallOption) (invpb.InventoryService_InteractiveOrderStockClient, error) {
+	stream, _ := f.fakeInventory.InteractiveOrderStock(ctx)
+	return &lateAlertStream{fakeOrderStream: stream.(*fakeOrderStream)}, nil
+}
+
+type lateAlertStream struct {
+	*fakeOrderStream
+	alerted bool
+}
+
+func (s *lateAlertStream) Recv() (*invpb.OrderItemResponse, error) {
+	resp, err := s.fakeOrderStream.Recv()
+	if err != nil && !s.alerted {
+		s.alerted = true
+		time.Sleep(10 * time.Millisecond)
+		return &invpb.OrderItemResponse{ProductId: "P001", LowStockAlert: true}, nil
+	}
+	return resp, err
+}
+
+// buildStream to strumień BuildOrder, który po wysłaniu reqs zrywa połączenie
+type buildStream struct {
+	grpc.ServerStream
+	reqs []*invpb.OrderItemRequest
+
+	mu       sync.Mutex
+	returned bool
+	late     int
+}
+
+func (s *buildStream) Context() context.Context { return context.Background() }
+
+func (s *buildStream) Recv() (*invpb.OrderItemRequest, error) {
+	if len(s.reqs) == 0 {
+		return nil, errors.New("client disconnected")
+	}
+	req := s.reqs[0]
+	s.reqs = s.reqs[1:]
+	return req, nil
+}
+
+func (s *buildStream) Send(*invpb.OrderItemResponse) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.returned {
+		s.late++
+	}
+	return nil
+}
+
+func TestBuildOrderStopsRelaysBeforeReturning(t *testing.T) {
+	s := newTestOrderServer(t, lateAlertInventory{&fakeInventory{}})
+	stream := &buildStream{reqs: []*invpb.OrderItemRequest{
+		{SessionId: "S1", ProductId: "P001", RequestedQuantity: 1, Action: invpb.OrderItemRequest_ADD},
+	}}
+	if err := s.BuildOrder(stream); err == nil {
+		t.Fatal("BuildOrder after a broken Recv: want error")
+	}
+	stream.mu.Lock()
+	stream.returned = true
+	stream.mu.Unlock()
+
+	// Gorutyna strumienia Inventory nie może już nic wysłać
+	time.Sleep(50 * time.Millisecond)
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+	if stream.late != 0 {
+		t.Errorf("%d messages sent after BuildOrder returned", stream.late)
+	}
+}
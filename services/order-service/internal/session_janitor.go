@@ -0,0 +1,189 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	orderpb "Service-sharing-environment-project/proto/order"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// DefaultSessionTTL jest krótszy od domyślnego TTL rezerwacji w Inventory (15m),
+// żeby sesja nie przeżyła swoich rezerwacji
+const DefaultSessionTTL = 10 * time.Minute
+
+var errSessionNotReleased = errors.New("one or more items could not be released")
+
+// restoreSessions odtwarza sesje otwartych (DRAFT, RESERVED) zamówień z trwałego
+// magazynu – po restarcie sesje w pamięci znikają, a bez nich wygaszanie nigdy
+// nie oddałoby zarezerwowanego towaru. Bezczynność liczona jest od UpdatedAt.
+func (s *OrderServer) restoreSessions() error {
+	orders, err := s.orders.List()
+	if err != nil {
+		return fmt.Errorf("restore sessions: %w", err)
+	}
+	latest := make(map[string]*orderpb.Order)
+	for _, o := range orders {
+		if o.SessionId == "" || (o.State != orderpb.OrderState_DRAFT && o.State != orderpb.OrderState_RESERVED) {
+			continue
+		}
+		if prev, ok := latest[o.SessionId]; ok && !prev.UpdatedAt.AsTime().Before(o.UpdatedAt.AsTime()) {
+			continue
+		}
+		latest[o.SessionId] = o
+	}
+	if len(latest) == 0 {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for sessionID, o := range latest {
+		c := newCart(o.UpdatedAt.AsTime())
+		c.orderID = o.OrderId
+		for _, line := range o.Items {
+			c.set(line.ProductId, line.Quantity)
+		}
+		s.sessions[sessionID] = c
+	}
+	slog.Info("sessions restored",
+		slog.String("component", "SessionJanitor"),
+		slog.Int("sessions", len(latest)),
+	)
+	return nil
+}
+
+// Close zatrzymuje wygaszanie bezczynnych sesji
+func (s *OrderServer) Close() {
+	select {
+	case <-s.janitorStop:
+		return
+	default:
+		close(s.janitorStop)
+	}
+	<-s.janitorDone
+}
+
+func (s *OrderServer) sweepLoop(interval time.Duration) {
+	defer close(s.janitorDone)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.janitorStop:
+			return
+		case now := <-ticker.C:
+			s.expireSessions(now)
+		}
+	}
+}
+
+// expireSessions wygasza sesje, w których od sessionTTL nie było żadnego żądania
+func (s *OrderServer) expireSessions(now time.Time) {
+	s.mu.Lock()
+	var idle []string
+	for sessionID, c := range s.sessions {
+		if now.Sub(c.lastActive) >= s.sessionTTL {
+			idle = append(idle, sessionID)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, sessionID := range idle {
+		select {
+		case <-s.janitorStop:
+			return
+		default:
+		}
+		s.expireSession(sessionID)
+	}
+}
+
+// expireSession anuluje zamówienie bezczynnej sesji tak jak CancelOrder: zwalnia jej
+// rezerwacje w Inventory i oddaje zdjęty towar. Gdy Inventory nie odda wszystkiego,
+// sesja zostaje i kolejne przejście sprzątania ponowi próbę.
+func (s *OrderServer) expireSession(sessionID string) {
+	ctx, span := otel.Tracer("order-service").Start(context.Background(), "ExpireSession")
+	defer span.End()
+	ctx = withSession(ctx, sessionID)
+
+	// Ta sama blokada sesji co w CancelOrder i FinalizeOrder; inne sesje
+	// nie czekają na wywołania Inventory
+	unlock := s.locks.lock(sessionID)
+	defer unlock()
+
+	// Sesja mogła zostać w międzyczasie użyta, sfinalizowana albo anulowana
+	s.mu.Lock()
+	c, ok := s.sessions[sessionID]
+	var (
+		orderID string
+		idle    time.Duration
+	)
+	if ok {
+		orderID, idle = c.orderID, time.Since(c.lastActive)
+	}
+	s.mu.Unlock()
+	if !ok || idle < s.sessionTTL {
+		return
+	}
+
+	var o *orderpb.Order
+	if orderID != "" {
+		var err error
+		if o, err = s.orders.Get(orderID); err != nil {
+			s.expiryFailed(ctx, span, sessionID, err)
+			return
+		}
+		// Zamówienie w toku finalizacji – sesję usunie FinalizeOrder
+		if o.State != orderpb.OrderState_DRAFT && o.State != orderpb.OrderState_RESERVED {
+			return
+		}
+	}
+
+	// Stan sprzed anulowania (UNSPECIFIED dla sesji bez zamówienia)
+	prev := o.GetState()
+	resp, err := s.cancelSession(ctx, "ExpireSession", sessionID, o)
+	if err == nil && !resp.Released {
+		err = errSessionNotReleased
+	}
+	if err != nil {
+		s.expiryFailed(ctx, span, sessionID, err)
+		return
+	}
+
+	span.AddEvent("order.session.expired", trace.WithAttributes(
+		attribute.String("order_id", resp.OrderId),
+		attribute.String("order.previous_state", prev.String()),
+		attribute.Float64("session.idle_seconds", idle.Seconds()),
+	))
+	s.expired.Add(ctx, 1)
+	if o != nil {
+		s.recordOutcome(ctx, outcomeExpired)
+	}
+	slog.InfoContext(ctx, "session expired",
+		slog.String("component", "SessionJanitor"),
+		slog.String("session_id", sessionID),
+		slog.String("order_id", resp.OrderId),
+		slog.Duration("idle", idle),
+		slog.Int("released_items", len(resp.ItemResults)),
+	)
+}
+
+// expiryFailed oznacza span błędem; sesja zostaje do kolejnego przejścia sprzątania
+func (s *OrderServer) expiryFailed(ctx context.Context, span trace.Span, sessionID string, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+	slog.WarnContext(ctx, "session expiry failed, retrying on next sweep",
+		slog.String("component", "SessionJanitor"),
+		slog.String("session_id", sessionID),
+		slog.Any("error", err),
+	)
+}
@@ -0,0 +1,142 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"sync"
+
+	invpb "Service-sharing-environment-project/proto/inventory"
+)
+
+var errRelayClosed = errors.New("inventory closed the InteractiveOrderStock stream")
+
+// stockRelay to strumień Inventory.InteractiveOrderStock jednej sesji BuildOrder.
+// Odpowiedzi na akcje koszyka trafiają do responses, a alerty niskiego stanu
+// są od razu przekazywane klientowi BuildOrder.
+type stockRelay struct {
+	stream    invpb.InventoryService_InteractiveOrderStockClient
+	responses chan *invpb.OrderItemResponse
+	// err jest ustawiany przed zamknięciem responses
+	err error
+}
+
+// receive odbiera wiadomości z Inventory do końca strumienia
+func (r *stockRelay) receive(ctx context.Context, sessionID string, send func(*invpb.OrderItemResponse) error) {
+	defer close(r.responses)
+	for {
+		resp, err := r.stream.Recv()
+		if err != nil {
+			if err != io.EOF {
+				r.err = err
+			}
+			return
+		}
+		if resp.LowStockAlert {
+			slog.InfoContext(ctx, "low stock alert relayed",
+				slog.String("rpc.method", "BuildOrder"),
+				slog.String("session_id", sessionID),
+				slog.String("product_id", resp.ProductId),
+				slog.Int("free_quantity", int(resp.AvailableQuantity)),
+			)
+			if err := send(resp); err != nil {
+				r.err = err
+				return
+			}
+			continue
+		}
+		select {
+		case r.responses <- resp:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// exchange wysyła akcję koszyka do Inventory i czeka na odpowiedź na nią
+func (r *stockRelay) exchange(ctx context.Context, req *invpb.OrderItemRequest) (*invpb.OrderItemResponse, error) {
+	// io.EOF oznacza zakończony strumień – właściwy błąd zwraca Recv
+	if err := r.stream.Send(req); err != nil && err != io.EOF {
+		return nil, err
+	}
+	select {
+	case resp, ok := <-r.responses:
+		if !ok {
+			if r.err != nil {
+				return nil, r.err
+			}
+			return nil, errRelayClosed
+		}
+		return resp, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// stockRelays to strumienie InteractiveOrderStock otwarte przez jedno wywołanie
+// BuildOrder, po jednym na sesję. Żyją w kontekście tego wywołania, więc
+// anulowanie BuildOrder przez klienta zamyka je również po stronie Inventory.
+type stockRelays struct {
+	ctx       context.Context
+	cancel    context.CancelFunc
+	inventory invpb.InventoryServiceClient
+	send      func(*invpb.OrderItemResponse) error
+	sessions  map[string]*stockRelay
+	// receivers to gorutyny receive – close czeka na nie, zanim BuildOrder wróci
+	receivers sync.WaitGroup
+}
+
+func newStockRelays(ctx context.Context, inventory invpb.InventoryServiceClient, send func(*invpb.OrderItemResponse) error) *stockRelays {
+	ctx, cancel := context.WithCancel(ctx)
+	return &stockRelays{
+		ctx:       ctx,
+		cancel:    cancel,
+		inventory: inventory,
+		send:      send,
+		sessions:  make(map[string]*stockRelay),
+	}
+}
+
+// get zwraca strumień sesji, otwierając go przy pierwszej akcji
+func (rs *stockRelays) get(sessionID string) (*stockRelay, error) {
+	if r, ok := rs.sessions[sessionID]; ok {
+		return r, nil
+	}
+	// session_id w baggage – Inventory oznacza nim swoje spany
+	ctx := withSession(rs.ctx, sessionID)
+	stream, err := rs.inventory.InteractiveOrderStock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	r := &stockRelay{stream: stream, responses: make(chan *invpb.OrderItemResponse)}
+	rs.receivers.Add(1)
+	go func() {
+		defer rs.receivers.Done()
+		r.receive(ctx, sessionID, rs.send)
+	}()
+	rs.sessions[sessionID] = r
+	slog.DebugContext(ctx, "inventory stream opened",
+		slog.String("rpc.method", "BuildOrder"),
+		slog.String("session_id", sessionID),
+	)
+	return r, nil
+}
+
+// closeSend kończy wszystkie strumienie po stronie Order i czeka, aż Inventory je zamknie
+func (rs *stockRelays) closeSend() {
+	for _, r := range rs.sessions {
+		if err := r.stream.CloseSend(); err != nil {
+			continue
+		}
+		for range r.responses {
+		}
+	}
+}
+
+// close anuluje strumienie i czeka na ich gorutyny, więc po powrocie żaden
+// alert nie trafi już do strumienia BuildOrder
+func (rs *stockRelays) close() {
+	rs.cancel()
+	rs.receivers.Wait()
+}
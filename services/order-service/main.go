@@ -2,133 +2,235 @@ package main
 
 import (
 	"context"
-	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net"
 	"os"
 	"time"
 
+	"Service-sharing-environment-project/config"
+	"Service-sharing-environment-project/healthcheck"
+	"Service-sharing-environment-project/interceptors"
+	"Service-sharing-environment-project/lifecycle"
 	invpb "Service-sharing-environment-project/proto/inventory"
 	orderpb "Service-sharing-environment-project/proto/order"
 	internal "Service-sharing-environment-project/services/order-service/internal"
 	telemetry "Service-sharing-environment-project/telemetry"
 
 	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc/filters"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
 )
 
 const (
-    orderServiceListenPort            = ":50052"
-    defaultInventoryServiceTargetPort = "50051"
+	defaultListenAddress = ":50052"
+	// telemetryFlushTimeout ogranicza czas wysyłki ostatnich spanów, metryk i logów przy wyjściu
+	telemetryFlushTimeout = 5 * time.Second
 )
 
-func getEnv(key, fallback string) string {
-    if v, ok := os.LookupEnv(key); ok {
-        return v
-    }
-    log.Printf("Warning: %s not set, using fallback %q", key, fallback)
-    return fallback
+// Test sprawdza połączenie z Inventory wywołaniami tylko do odczytu – nie może
+// zmieniać stanu magazynu, bo trwały magazyn zachowałby zmianę po każdym restarcie
+func Test(client invpb.InventoryServiceClient) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// 1) GetProductInfo
+	pid := &invpb.ProductId{ProductId: "P001"}
+	info, err := client.GetProductInfo(ctx, pid)
+	if err != nil {
+		slog.Warn("smoke test: GetProductInfo failed", slog.Any("error", err))
+	} else {
+		slog.Info("smoke test: product info",
+			slog.String("product_id", info.ProductId),
+			slog.String("name", info.Name),
+			slog.Int("available_quantity", int(info.AvailableQuantity)),
+		)
+	}
+
+	// 2) ListProducts
+	stream, err := client.ListProducts(ctx, &invpb.ProductFilter{IncludeDiscontinued: true})
+	if err != nil {
+		slog.Warn("smoke test: ListProducts failed", slog.Any("error", err))
+		return
+	}
+	for {
+		p, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			slog.Warn("smoke test: ListProducts stream failed", slog.Any("error", err))
+			break
+		}
+		slog.Info("smoke test: product listed",
+			slog.String("product_id", p.ProductId),
+			slog.String("name", p.Name),
+			slog.Int("available_quantity", int(p.AvailableQuantity)),
+		)
+	}
 }
 
-func Test(client invpb.InventoryServiceClient) {
-    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-    defer cancel()
-
-    // 1) GetProductInfo
-    pid := &invpb.ProductId{ProductId: "P001"}
-    info, err := client.GetProductInfo(ctx, pid)
-    if err != nil {
-        log.Printf("GetProductInfo error: %v", err)
-    } else {
-        fmt.Printf("Product Info: %+v\n", info)
-    }
-
-    // 2) AdjustStock
-    adj := &invpb.StockAdjustment{ProductId: "P001", QuantityChange: 5}
-    resp2, err := client.AdjustStock(ctx, adj)
-    if err != nil {
-        log.Printf("AdjustStock error: %v", err)
-    } else {
-        fmt.Printf("AdjustStock response: %+v\n", resp2)
-    }
-
-    // 3) ListProducts
-    stream, err := client.ListProducts(ctx, &invpb.ProductFilter{IncludeDiscontinued: true})
-    if err != nil {
-        log.Printf("ListProducts error: %v", err)
-        return
-    }
-    fmt.Println("Products:")
-    for {
-        p, err := stream.Recv()
-        if err == io.EOF {
-            break
-        }
-        if err != nil {
-            log.Printf("Stream error: %v", err)
-            break
-        }
-        fmt.Printf("- %s (%s): %d in stock\n", p.Name, p.ProductId, p.AvailableQuantity)
-    }
+// fatal loguje błąd startu i kończy proces kodem 1 (defery nie są wykonywane)
+func fatal(msg string, err error) {
+	slog.Error(msg, slog.Any("error", err))
+	os.Exit(1)
+}
+
+// orderStoreConfig mapuje sekcję orders konfiguracji na internal.OrderStoreConfig
+func orderStoreConfig(cfg config.Orders) internal.OrderStoreConfig {
+	return internal.OrderStoreConfig{
+		Kind:             cfg.Kind,
+		DataDir:          cfg.DataDir,
+		SnapshotInterval: cfg.SnapshotInterval,
+	}
 }
 
 func main() {
-    ctx := context.Background()
-
-    // ── Tracing setup ─────────────────────────────────────────────────────────
-    tp, err := telemetry.InitTracer(ctx, "order-service")
-    if err != nil {
-        log.Fatalf("[Order] tracer init error: %v", err)
-    }
-    defer func() {
-        if err := tp.Shutdown(ctx); err != nil {
-            log.Printf("[Order] error shutting down tracer: %v", err)
-        }
-    }()
-
-    // ── Metrics setup (OTLP/gRPC) ──────────────────────────────────────────────
-    mp, err := telemetry.InitMetrics(ctx, "order-service")
-    if err != nil {
-        log.Fatalf("[Order] metrics init error: %v", err)
-    }
-
-    // ── Connect to Inventory Service ─────────────────────────────────────────
-    invTarget := getEnv("INVENTORY_SERVICE_ENDPOINT", "localhost:"+defaultInventoryServiceTargetPort)
-    log.Printf("[Order] connecting to Inventory at %s", invTarget)
-
-    conn, err := grpc.DialContext(ctx, invTarget,
-        grpc.WithTransportCredentials(insecure.NewCredentials()),
-        grpc.WithStatsHandler(otelgrpc.NewClientHandler()),
-    )
-    if err != nil {
-        log.Fatalf("[Order] failed to dial inventory: %v", err)
-    }
-    defer conn.Close()
-
-    invClient := invpb.NewInventoryServiceClient(conn)
-    log.Println("[Order] connected to Inventory.")
-
-    // quick background smoke‐test
-    go Test(invClient)
-
-    // ── Start gRPC Server ────────────────────────────────────────────────────
-    lis, err := net.Listen("tcp", orderServiceListenPort)
-    if err != nil {
-        log.Fatalf("[Order] listen error: %v", err)
-    }
-
-    grpcServer := grpc.NewServer(
-        grpc.StatsHandler(otelgrpc.NewServerHandler()), // server‐side StatsHandler :contentReference[oaicite:3]{index=3}
-    )
-
-    // Przekazujemy meter do konstruktora serwera
-    orderSrv := internal.NewOrderServer(invClient, mp.Meter("order-service"))
-    orderpb.RegisterOrderServiceServer(grpcServer, orderSrv)
-
-    log.Printf("[Order] gRPC listening on %s", orderServiceListenPort)
-    if err := grpcServer.Serve(lis); err != nil {
-        log.Fatalf("[Order] Serve() error: %v", err)
-    }
+	// ctx jest anulowany po SIGINT/SIGTERM – wtedy serwer przechodzi w tryb wygaszania
+	ctx, stop := lifecycle.SignalContext()
+	defer stop()
+
+	// ── Configuration ─────────────────────────────────────────────────────────
+	cfg, err := config.Load(config.Defaults("order-service", defaultListenAddress), os.Args[1:])
+	if err != nil {
+		fatal("invalid configuration", err)
+	}
+
+	// ── Logging setup (JSON na stdout + OTLP) ─────────────────────────────────
+	logger, lp, err := telemetry.InitLogger(ctx, cfg.Telemetry)
+	if err != nil {
+		fatal("logger init failed", err)
+	}
+	defer func() {
+		flushCtx, cancel := context.WithTimeout(context.Background(), telemetryFlushTimeout)
+		defer cancel()
+		if err := lp.Shutdown(flushCtx); err != nil {
+			slog.Error("logger provider shutdown failed", slog.Any("error", err))
+		}
+	}()
+
+	// ── Tracing setup ─────────────────────────────────────────────────────────
+	tp, err := telemetry.InitTracer(ctx, cfg.Telemetry)
+	if err != nil {
+		fatal("tracer init failed", err)
+	}
+	defer func() {
+		flushCtx, cancel := context.WithTimeout(context.Background(), telemetryFlushTimeout)
+		defer cancel()
+		if err := tp.Shutdown(flushCtx); err != nil {
+			slog.Error("tracer provider shutdown failed", slog.Any("error", err))
+		}
+	}()
+
+	// ── Metrics setup (OTLP/gRPC) ──────────────────────────────────────────────
+	mp, err := telemetry.InitMetrics(ctx, cfg.Telemetry)
+	if err != nil {
+		fatal("metrics init failed", err)
+	}
+	defer func() {
+		flushCtx, cancel := context.WithTimeout(context.Background(), telemetryFlushTimeout)
+		defer cancel()
+		if err := mp.Shutdown(flushCtx); err != nil {
+			slog.Error("meter provider shutdown failed", slog.Any("error", err))
+		}
+	}()
+
+	// ── Profiling (pprof / Pyroscope) ─────────────────────────────────────────
+	profiler, err := telemetry.InitProfiling(cfg.Telemetry)
+	if err != nil {
+		fatal("profiling init failed", err)
+	}
+	defer func() {
+		flushCtx, cancel := context.WithTimeout(context.Background(), telemetryFlushTimeout)
+		defer cancel()
+		if err := profiler.Shutdown(flushCtx); err != nil {
+			slog.Error("profiler shutdown failed", slog.Any("error", err))
+		}
+	}()
+
+	// ── Order store ───────────────────────────────────────────────────────────
+	storeCfg := orderStoreConfig(cfg.Orders)
+	orders, err := internal.OpenOrderStore(storeCfg)
+	if err != nil {
+		fatal("order store init failed", err)
+	}
+	defer func() {
+		if err := orders.Close(); err != nil {
+			slog.Error("closing order store failed", slog.Any("error", err))
+		}
+	}()
+	slog.Info("order store opened", slog.String("kind", storeCfg.Kind))
+
+	// ── Connect to Inventory Service ─────────────────────────────────────────
+	invTarget := cfg.Inventory.Endpoint
+	slog.Info("connecting to inventory",
+		slog.String("target", invTarget),
+		slog.Duration("request_timeout", cfg.Timeouts.Request),
+	)
+
+	conn, err := grpc.DialContext(ctx, invTarget,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithStatsHandler(otelgrpc.NewClientHandler(otelgrpc.WithFilter(filters.Not(filters.HealthCheck())))),
+		grpc.WithChainUnaryInterceptor(interceptors.UnaryClientTimeout(cfg.Timeouts.Request)),
+	)
+	if err != nil {
+		fatal("dialing inventory failed", err)
+	}
+	defer conn.Close()
+
+	invClient := invpb.NewInventoryServiceClient(conn)
+	slog.Info("inventory client ready", slog.String("target", invTarget))
+
+	// quick background smoke‐test
+	go Test(invClient)
+
+	// ── Start gRPC Server ────────────────────────────────────────────────────
+	lis, err := net.Listen("tcp", cfg.ListenAddress)
+	if err != nil {
+		fatal("listen failed", err)
+	}
+
+	// Wspólne interceptory: licznik żądań, histogram opóźnień i log każdego RPC
+	interceptorOpts := []interceptors.Option{
+		interceptors.WithLogger(logger),
+		interceptors.WithBaggageKeys(cfg.Telemetry.MetricKeys()...),
+	}
+	if cfg.Telemetry.Profiling.Enabled() {
+		// Etykiety pprof rpc_service/rpc_method pozwalają filtrować profile per RPC
+		interceptorOpts = append(interceptorOpts, interceptors.WithProfilingLabels())
+	}
+	rpcInterceptors, err := interceptors.New(mp.Meter("order-service"), "order", interceptorOpts...)
+	if err != nil {
+		fatal("interceptors init failed", err)
+	}
+
+	grpcServer := grpc.NewServer(append(
+		rpcInterceptors.ServerOptions(),
+		grpc.StatsHandler(otelgrpc.NewServerHandler(otelgrpc.WithFilter(filters.Not(filters.HealthCheck())))), // server‐side StatsHandler :contentReference[oaicite:3]{index=3}
+	)...)
+
+	orderSrv, err := internal.NewOrderServer(invClient, orders, mp.Meter("order-service"), cfg.Sessions.TTL)
+	if err != nil {
+		fatal("order server init failed", err)
+	}
+	// Wygaszanie sesji zatrzymywane przed zamknięciem magazynu zamówień i połączenia z Inventory
+	defer orderSrv.Close()
+	slog.Info("session expiry enabled", slog.Duration("ttl", cfg.Sessions.TTL))
+	orderpb.RegisterOrderServiceServer(grpcServer, orderSrv)
+
+	// Health: OrderService jest gotowy tylko wtedy, gdy Inventory odpowiada SERVING
+	healthSrv := healthcheck.Register(grpcServer, cfg.Reflection, orderpb.OrderService_ServiceDesc.ServiceName)
+	go healthcheck.MonitorDependency(ctx, healthSrv, conn,
+		invpb.InventoryService_ServiceDesc.ServiceName, cfg.HealthCheckInterval,
+		orderpb.OrderService_ServiceDesc.ServiceName,
+	)
+
+	slog.Info("gRPC server listening", slog.String("address", cfg.ListenAddress))
+	// Po sygnale: zamknięcie strumieni WatchOrder, wygaszenie RPC (w tym strumieni BuildOrder) w okresie karencji,
+	// a następnie (defer) zamknięcie połączenia z Inventory i wysyłka telemetrii
+	if err := lifecycle.Serve(ctx, grpcServer, lis, cfg.Timeouts.Shutdown, healthSrv.Shutdown, orderSrv.Shutdown); err != nil {
+		slog.Error("gRPC server failed", slog.Any("error", err))
+	}
+	slog.Info("gRPC server stopped")
 }
@@ -0,0 +1,73 @@
+package telemetry
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/baggage"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// Baggage keys understood by both services.
+const (
+	BaggageSessionID  = "session_id"
+	BaggageCustomerID = "customer_id"
+	BaggageTenantID   = "tenant_id"
+)
+
+var (
+	// DefaultSpanBaggageKeys are copied onto every span started within a request.
+	DefaultSpanBaggageKeys = []string{BaggageSessionID, BaggageCustomerID, BaggageTenantID}
+	// DefaultMetricBaggageKeys are added to RPC metrics; session and customer ids
+	// are left out on purpose because they would explode metric cardinality.
+	DefaultMetricBaggageKeys = []string{BaggageTenantID}
+)
+
+// Propagator is the W3C trace context + baggage propagator installed by InitTracer.
+func Propagator() propagation.TextMapPropagator {
+	return propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+}
+
+// BaggageAttributes returns an attribute for each of keys present in the
+// baggage of ctx; the attribute key equals the baggage key.
+func BaggageAttributes(ctx context.Context, keys []string) []attribute.KeyValue {
+	if len(keys) == 0 {
+		return nil
+	}
+	bag := baggage.FromContext(ctx)
+	var attrs []attribute.KeyValue
+	for _, key := range keys {
+		if m := bag.Member(key); m.Key() != "" {
+			attrs = append(attrs, attribute.String(key, m.Value()))
+		}
+	}
+	return attrs
+}
+
+// WithBaggage returns ctx with the baggage member key=value added (or replaced).
+// Invalid values are ignored so that instrumentation never fails a request.
+func WithBaggage(ctx context.Context, key, value string) context.Context {
+	m, err := baggage.NewMemberRaw(key, value)
+	if err != nil {
+		return ctx
+	}
+	bag, err := baggage.FromContext(ctx).SetMember(m)
+	if err != nil {
+		return ctx
+	}
+	return baggage.ContextWithBaggage(ctx, bag)
+}
+
+// baggageSpanProcessor promotes selected baggage entries to span attributes.
+type baggageSpanProcessor struct {
+	keys []string
+}
+
+func (p baggageSpanProcessor) OnStart(parent context.Context, s sdktrace.ReadWriteSpan) {
+	s.SetAttributes(BaggageAttributes(parent, p.keys)...)
+}
+
+func (baggageSpanProcessor) OnEnd(sdktrace.ReadOnlySpan)      {}
+func (baggageSpanProcessor) Shutdown(context.Context) error   { return nil }
+func (baggageSpanProcessor) ForceFlush(context.Context) error { return nil }
@@ -0,0 +1,336 @@
+package telemetry
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/contrib/instrumentation/runtime"
+	"go.opentelemetry.io/otel/exporters/otlp/otlplog/otlploggrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlplog/otlploghttp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	otelprom "go.opentelemetry.io/otel/exporters/prometheus"
+	"go.opentelemetry.io/otel/exporters/stdout/stdoutmetric"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	sdklog "go.opentelemetry.io/otel/sdk/log"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"google.golang.org/grpc/credentials"
+)
+
+// Exporter names, as accepted by OTEL_TRACES_EXPORTER, OTEL_METRICS_EXPORTER
+// and OTEL_LOGS_EXPORTER. ExporterPrometheus applies to metrics only.
+const (
+	ExporterOTLP       = "otlp"
+	ExporterConsole    = "console"
+	ExporterPrometheus = "prometheus"
+	ExporterNone       = "none"
+)
+
+// OTLP protocols, as accepted by OTEL_EXPORTER_OTLP_PROTOCOL.
+const (
+	OTLPProtocolGRPC = "grpc"
+	OTLPProtocolHTTP = "http/protobuf"
+)
+
+const (
+	// DefaultOTLPHTTPEndpoint is used when Config.OTLPEndpoint is empty and
+	// the protocol is http/protobuf.
+	DefaultOTLPHTTPEndpoint = "localhost:4318"
+	// DefaultPrometheusAddress is where the Prometheus exporter serves /metrics.
+	DefaultPrometheusAddress = ":9464"
+)
+
+// ExportersConfig selects where traces, metrics and logs are sent.
+type ExportersConfig struct {
+	// Traces is otlp (default), console or none.
+	Traces string `yaml:"traces"`
+	// Metrics is otlp (default), prometheus, console or none.
+	Metrics string `yaml:"metrics"`
+	// Logs is otlp (default) or none; the JSON stdout log is written either way,
+	// so console is accepted as an alias of none.
+	Logs string `yaml:"logs"`
+	// PrometheusAddress is the listen address of the /metrics endpoint
+	// (default DefaultPrometheusAddress).
+	PrometheusAddress string `yaml:"prometheus_address"`
+
+	OTLP OTLPConfig `yaml:"otlp"`
+}
+
+// OTLPConfig holds the transport settings shared by the OTLP exporters of
+// every signal; the endpoint is Config.OTLPEndpoint.
+type OTLPConfig struct {
+	// Protocol is grpc (default) or http/protobuf.
+	Protocol string `yaml:"protocol"`
+	// Insecure disables TLS for an endpoint given as host:port; for a URL
+	// endpoint the scheme (http or https) decides.
+	Insecure bool `yaml:"insecure"`
+	// Certificate is a PEM file with the CA certificates trusted for TLS;
+	// empty uses the system pool.
+	Certificate string `yaml:"certificate"`
+	// Headers are sent with every export request (e.g. authorization).
+	Headers map[string]string `yaml:"headers"`
+}
+
+// Validate checks the exporter and protocol names.
+func (c ExportersConfig) Validate() error {
+	var errs []error
+	switch c.Traces {
+	case "", ExporterOTLP, ExporterConsole, ExporterNone:
+	default:
+		errs = append(errs, fmt.Errorf("unknown traces exporter %q", c.Traces))
+	}
+	switch c.Metrics {
+	case "", ExporterOTLP, ExporterPrometheus, ExporterConsole, ExporterNone:
+	default:
+		errs = append(errs, fmt.Errorf("unknown metrics exporter %q", c.Metrics))
+	}
+	switch c.Logs {
+	case "", ExporterOTLP, ExporterConsole, ExporterNone:
+	default:
+		errs = append(errs, fmt.Errorf("unknown logs exporter %q", c.Logs))
+	}
+	switch c.OTLP.Protocol {
+	case "", OTLPProtocolGRPC, OTLPProtocolHTTP:
+	default:
+		errs = append(errs, fmt.Errorf("unknown OTLP protocol %q", c.OTLP.Protocol))
+	}
+	return errors.Join(errs...)
+}
+
+func (c ExportersConfig) prometheusAddress() string {
+	if c.PrometheusAddress == "" {
+		return DefaultPrometheusAddress
+	}
+	return c.PrometheusAddress
+}
+
+// otlpTarget is the resolved OTLP endpoint of Config.
+type otlpTarget struct {
+	http     bool
+	endpoint string // host:port
+	basePath string // path prefix of a URL endpoint (HTTP only)
+	tls      *tls.Config
+	headers  map[string]string
+}
+
+// urlPath returns the HTTP path of a signal, e.g. "/v1/traces".
+func (t otlpTarget) urlPath(signal string) string {
+	return t.basePath + "/v1/" + signal
+}
+
+func (c Config) otlpTarget() (otlpTarget, error) {
+	otlp := c.Exporters.OTLP
+	t := otlpTarget{
+		http:     otlp.Protocol == OTLPProtocolHTTP,
+		endpoint: c.endpoint(),
+		headers:  otlp.Headers,
+	}
+	insecure := otlp.Insecure
+	if strings.Contains(t.endpoint, "://") {
+		u, err := url.Parse(t.endpoint)
+		if err != nil {
+			return otlpTarget{}, fmt.Errorf("otlp endpoint: %w", err)
+		}
+		t.endpoint, t.basePath = u.Host, strings.TrimSuffix(u.Path, "/")
+		insecure = u.Scheme == "http"
+	}
+	if insecure {
+		return t, nil
+	}
+
+	t.tls = &tls.Config{MinVersion: tls.VersionTLS12}
+	if otlp.Certificate != "" {
+		pem, err := os.ReadFile(otlp.Certificate)
+		if err != nil {
+			return otlpTarget{}, fmt.Errorf("otlp certificate: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return otlpTarget{}, fmt.Errorf("otlp certificate %s: no PEM certificates found", otlp.Certificate)
+		}
+		t.tls.RootCAs = pool
+	}
+	return t, nil
+}
+
+// newSpanExporter creates the configured span exporter; nil means none.
+func newSpanExporter(ctx context.Context, cfg Config) (sdktrace.SpanExporter, error) {
+	switch cfg.Exporters.Traces {
+	case "", ExporterOTLP:
+		t, err := cfg.otlpTarget()
+		if err != nil {
+			return nil, err
+		}
+		if t.http {
+			opts := []otlptracehttp.Option{
+				otlptracehttp.WithEndpoint(t.endpoint),
+				otlptracehttp.WithURLPath(t.urlPath("traces")),
+				otlptracehttp.WithHeaders(t.headers),
+			}
+			if t.tls == nil {
+				opts = append(opts, otlptracehttp.WithInsecure())
+			} else {
+				opts = append(opts, otlptracehttp.WithTLSClientConfig(t.tls))
+			}
+			return otlptracehttp.New(ctx, opts...)
+		}
+		opts := []otlptracegrpc.Option{
+			otlptracegrpc.WithEndpoint(t.endpoint),
+			otlptracegrpc.WithHeaders(t.headers),
+		}
+		if t.tls == nil {
+			opts = append(opts, otlptracegrpc.WithInsecure())
+		} else {
+			opts = append(opts, otlptracegrpc.WithTLSCredentials(credentials.NewTLS(t.tls)))
+		}
+		return otlptracegrpc.New(ctx, opts...)
+	case ExporterConsole:
+		return stdouttrace.New(stdouttrace.WithPrettyPrint())
+	case ExporterNone:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unknown traces exporter %q", cfg.Exporters.Traces)
+	}
+}
+
+// newMetricReader creates the reader of the configured metric exporter;
+// nil means none. The OTLP and console readers also collect the
+// go.schedule.duration histogram of the runtime producer; the Prometheus
+// exporter would report its scope twice (otel_scope_info) and fail the scrape.
+func newMetricReader(ctx context.Context, cfg Config) (sdkmetric.Reader, error) {
+	switch cfg.Exporters.Metrics {
+	case "", ExporterOTLP:
+		t, err := cfg.otlpTarget()
+		if err != nil {
+			return nil, err
+		}
+		var exp sdkmetric.Exporter
+		if t.http {
+			opts := []otlpmetrichttp.Option{
+				otlpmetrichttp.WithEndpoint(t.endpoint),
+				otlpmetrichttp.WithURLPath(t.urlPath("metrics")),
+				otlpmetrichttp.WithHeaders(t.headers),
+			}
+			if t.tls == nil {
+				opts = append(opts, otlpmetrichttp.WithInsecure())
+			} else {
+				opts = append(opts, otlpmetrichttp.WithTLSClientConfig(t.tls))
+			}
+			exp, err = otlpmetrichttp.New(ctx, opts...)
+		} else {
+			opts := []otlpmetricgrpc.Option{
+				otlpmetricgrpc.WithEndpoint(t.endpoint),
+				otlpmetricgrpc.WithHeaders(t.headers),
+			}
+			if t.tls == nil {
+				opts = append(opts, otlpmetricgrpc.WithInsecure())
+			} else {
+				opts = append(opts, otlpmetricgrpc.WithTLSCredentials(credentials.NewTLS(t.tls)))
+			}
+			exp, err = otlpmetricgrpc.New(ctx, opts...)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return sdkmetric.NewPeriodicReader(exp, sdkmetric.WithProducer(runtime.NewProducer())), nil
+	case ExporterConsole:
+		exp, err := stdoutmetric.New(stdoutmetric.WithPrettyPrint())
+		if err != nil {
+			return nil, err
+		}
+		return sdkmetric.NewPeriodicReader(exp, sdkmetric.WithProducer(runtime.NewProducer())), nil
+	case ExporterPrometheus:
+		return newPrometheusReader(cfg.Exporters.prometheusAddress())
+	case ExporterNone:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unknown metrics exporter %q", cfg.Exporters.Metrics)
+	}
+}
+
+// newLogExporter creates the configured OTLP log exporter; nil means none.
+func newLogExporter(ctx context.Context, cfg Config) (sdklog.Exporter, error) {
+	switch cfg.Exporters.Logs {
+	case "", ExporterOTLP:
+		t, err := cfg.otlpTarget()
+		if err != nil {
+			return nil, err
+		}
+		if t.http {
+			opts := []otlploghttp.Option{
+				otlploghttp.WithEndpoint(t.endpoint),
+				otlploghttp.WithURLPath(t.urlPath("logs")),
+				otlploghttp.WithHeaders(t.headers),
+			}
+			if t.tls == nil {
+				opts = append(opts, otlploghttp.WithInsecure())
+			} else {
+				opts = append(opts, otlploghttp.WithTLSClientConfig(t.tls))
+			}
+			return otlploghttp.New(ctx, opts...)
+		}
+		opts := []otlploggrpc.Option{
+			otlploggrpc.WithEndpoint(t.endpoint),
+			otlploggrpc.WithHeaders(t.headers),
+		}
+		if t.tls == nil {
+			opts = append(opts, otlploggrpc.WithInsecure())
+		} else {
+			opts = append(opts, otlploggrpc.WithTLSCredentials(credentials.NewTLS(t.tls)))
+		}
+		return otlploggrpc.New(ctx, opts...)
+	case ExporterConsole, ExporterNone:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unknown logs exporter %q", cfg.Exporters.Logs)
+	}
+}
+
+// prometheusReader serves the metrics of its own registry on /metrics and
+// stops the HTTP server when the MeterProvider shuts it down.
+type prometheusReader struct {
+	sdkmetric.Reader
+	server *http.Server
+}
+
+func newPrometheusReader(addr string) (*prometheusReader, error) {
+	registry := prometheus.NewRegistry()
+	exp, err := otelprom.New(otelprom.WithRegisterer(registry))
+	if err != nil {
+		return nil, err
+	}
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	// OpenMetrics jest potrzebny, żeby Prometheus odczytał exemplary
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{EnableOpenMetrics: true}))
+	r := &prometheusReader{Reader: exp, server: &http.Server{Handler: mux}}
+	go func() {
+		if err := r.server.Serve(lis); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("prometheus endpoint failed", slog.Any("error", err))
+		}
+	}()
+	slog.Info("prometheus endpoint listening", slog.String("address", lis.Addr().String()))
+	return r, nil
+}
+
+func (r *prometheusReader) Shutdown(ctx context.Context) error {
+	return errors.Join(r.server.Shutdown(ctx), r.Reader.Shutdown(ctx))
+}
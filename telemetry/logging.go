@@ -0,0 +1,126 @@
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+
+	"go.opentelemetry.io/contrib/bridges/otelslog"
+	"go.opentelemetry.io/otel/log/global"
+	sdklog "go.opentelemetry.io/otel/sdk/log"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// InitLogger sets up the log exporter selected by cfg.Exporters.Logs (OTLP
+// over gRPC by default), installs a LoggerProvider and returns a slog logger
+// that writes every record both to the logs SDK and as JSON to stdout. Records logged with a context carry the trace_id and
+// span_id of its span, so logs in Loki link back to traces in Tempo. The
+// logger also becomes slog.Default; the SDK provider is returned so that
+// callers can flush it with Shutdown on exit.
+func InitLogger(ctx context.Context, cfg Config) (*slog.Logger, *sdklog.LoggerProvider, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(cfg.logLevel())); err != nil {
+		return nil, nil, err
+	}
+
+	logExp, err := newLogExporter(ctx, cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	res, err := newResource(ctx, cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	opts := []sdklog.LoggerProviderOption{sdklog.WithResource(res)}
+	if logExp != nil {
+		opts = append(opts, sdklog.WithProcessor(sdklog.NewBatchProcessor(logExp)))
+	}
+	lp := sdklog.NewLoggerProvider(opts...)
+	global.SetLoggerProvider(lp)
+
+	logger := slog.New(fanoutHandler{
+		level: level,
+		handlers: []slog.Handler{
+			// Most OTLP korzysta z kontekstu rekordu, więc trace_id/span_id dołącza sam
+			otelslog.NewHandler(cfg.ServiceName, otelslog.WithLoggerProvider(lp)),
+			traceHandler{next: slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: level})},
+		},
+	})
+	slog.SetDefault(logger)
+	return logger, lp, nil
+}
+
+// fanoutHandler passes every record at or above level to all handlers.
+type fanoutHandler struct {
+	level    slog.Leveler
+	handlers []slog.Handler
+}
+
+func (h fanoutHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	if level < h.level.Level() {
+		return false
+	}
+	for _, next := range h.handlers {
+		if next.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h fanoutHandler) Handle(ctx context.Context, r slog.Record) error {
+	var errs []error
+	for _, next := range h.handlers {
+		if next.Enabled(ctx, r.Level) {
+			errs = append(errs, next.Handle(ctx, r.Clone()))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (h fanoutHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.with(func(next slog.Handler) slog.Handler { return next.WithAttrs(attrs) })
+}
+
+func (h fanoutHandler) WithGroup(name string) slog.Handler {
+	return h.with(func(next slog.Handler) slog.Handler { return next.WithGroup(name) })
+}
+
+func (h fanoutHandler) with(apply func(slog.Handler) slog.Handler) slog.Handler {
+	handlers := make([]slog.Handler, len(h.handlers))
+	for i, next := range h.handlers {
+		handlers[i] = apply(next)
+	}
+	return fanoutHandler{level: h.level, handlers: handlers}
+}
+
+// traceHandler adds the trace_id and span_id of the span in the record's
+// context to every record.
+type traceHandler struct {
+	next slog.Handler
+}
+
+func (h traceHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h traceHandler) Handle(ctx context.Context, r slog.Record) error {
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		r.AddAttrs(
+			slog.String("trace_id", sc.TraceID().String()),
+			slog.String("span_id", sc.SpanID().String()),
+		)
+	}
+	return h.next.Handle(ctx, r)
+}
+
+func (h traceHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return traceHandler{next: h.next.WithAttrs(attrs)}
+}
+
+func (h traceHandler) WithGroup(name string) slog.Handler {
+	return traceHandler{next: h.next.WithGroup(name)}
+}
@@ -0,0 +1,59 @@
+package telemetry
+
+import (
+	"fmt"
+
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/exemplar"
+)
+
+// Exemplar filter names, as accepted by OTEL_METRICS_EXEMPLAR_FILTER.
+const (
+	ExemplarFilterTraceBased = "trace_based"
+	ExemplarFilterAlwaysOn   = "always_on"
+	ExemplarFilterAlwaysOff  = "always_off"
+)
+
+// LatencyBuckets are the histogram bucket boundaries, in seconds, applied to
+// every histogram recorded in seconds. They are dense between 1ms and 100ms,
+// where in-cluster gRPC calls usually complete, and reach 10s for calls that
+// run into their deadline.
+var LatencyBuckets = []float64{
+	0.0005, 0.001, 0.0025, 0.005, 0.0075, 0.01, 0.025, 0.05, 0.075,
+	0.1, 0.25, 0.5, 0.75, 1, 2.5, 5, 10,
+}
+
+// newExemplarFilter maps an exemplar filter name to the SDK filter; the
+// trace-based filter (the default) keeps exemplars of sampled spans only,
+// so every exemplar links to a trace that reached Tempo.
+func newExemplarFilter(name string) (exemplar.Filter, error) {
+	switch name {
+	case "", ExemplarFilterTraceBased:
+		return exemplar.TraceBasedFilter, nil
+	case ExemplarFilterAlwaysOn:
+		return exemplar.AlwaysOnFilter, nil
+	case ExemplarFilterAlwaysOff:
+		return exemplar.AlwaysOffFilter, nil
+	default:
+		return nil, fmt.Errorf("unknown exemplar filter %q", name)
+	}
+}
+
+// latencyViews applies LatencyBuckets to histograms recorded in seconds and,
+// scaled, to those recorded in milliseconds (e.g. otelgrpc's rpc.server.duration).
+func latencyViews() []sdkmetric.View {
+	millis := make([]float64, len(LatencyBuckets))
+	for i, b := range LatencyBuckets {
+		millis[i] = b * 1000
+	}
+	return []sdkmetric.View{
+		sdkmetric.NewView(
+			sdkmetric.Instrument{Kind: sdkmetric.InstrumentKindHistogram, Unit: "s"},
+			sdkmetric.Stream{Aggregation: sdkmetric.AggregationExplicitBucketHistogram{Boundaries: LatencyBuckets}},
+		),
+		sdkmetric.NewView(
+			sdkmetric.Instrument{Kind: sdkmetric.InstrumentKindHistogram, Unit: "ms"},
+			sdkmetric.Stream{Aggregation: sdkmetric.AggregationExplicitBucketHistogram{Boundaries: millis}},
+		),
+	}
+}
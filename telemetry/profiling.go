@@ -0,0 +1,118 @@
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/pprof"
+
+	"github.com/grafana/pyroscope-go"
+)
+
+// ProfilingConfig enables continuous profiling; both parts are optional.
+type ProfilingConfig struct {
+	// PprofAddress serves net/http/pprof on this address (e.g. ":6060"); empty disables it.
+	PprofAddress string `yaml:"pprof_address"`
+	// PyroscopeEndpoint is the Pyroscope-compatible server profiles are pushed
+	// to (e.g. "http://pyroscope:4040"); empty disables push mode.
+	PyroscopeEndpoint string `yaml:"pyroscope_endpoint"`
+}
+
+// Enabled reports whether any profiling output is configured.
+func (c ProfilingConfig) Enabled() bool {
+	return c.PprofAddress != "" || c.PyroscopeEndpoint != ""
+}
+
+// Profiler owns the pprof HTTP server and the Pyroscope push agent.
+type Profiler struct {
+	server    *http.Server
+	pyroscope *pyroscope.Profiler
+}
+
+// InitProfiling starts the profiling outputs enabled in cfg.Profiling. CPU
+// samples carry the pprof labels set while they were taken: span_id and
+// span_name of the local root span (see InitTracer) and the RPC labels added
+// by the interceptors, so flame graphs can be filtered per RPC and opened
+// from a trace. The returned Profiler must be stopped with Shutdown.
+func InitProfiling(cfg Config) (*Profiler, error) {
+	p := &Profiler{}
+	if addr := cfg.Profiling.PprofAddress; addr != "" {
+		lis, err := net.Listen("tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		p.server = &http.Server{Handler: pprofHandler()}
+		go func() {
+			if err := p.server.Serve(lis); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				slog.Error("pprof server failed", slog.Any("error", err))
+			}
+		}()
+		slog.Info("pprof endpoint listening", slog.String("address", lis.Addr().String()))
+	}
+
+	if endpoint := cfg.Profiling.PyroscopeEndpoint; endpoint != "" {
+		tags := map[string]string{}
+		if cfg.Environment != "" {
+			tags["environment"] = cfg.Environment
+		}
+		agent, err := pyroscope.Start(pyroscope.Config{
+			ApplicationName: cfg.ServiceName,
+			ServerAddress:   endpoint,
+			Tags:            tags,
+			ProfileTypes: []pyroscope.ProfileType{
+				pyroscope.ProfileCPU,
+				pyroscope.ProfileAllocObjects,
+				pyroscope.ProfileAllocSpace,
+				pyroscope.ProfileInuseObjects,
+				pyroscope.ProfileInuseSpace,
+				pyroscope.ProfileGoroutines,
+			},
+		})
+		if err != nil {
+			if p.server != nil {
+				p.server.Close()
+			}
+			return nil, err
+		}
+		p.pyroscope = agent
+		slog.Info("pushing profiles to pyroscope", slog.String("endpoint", endpoint))
+	}
+	return p, nil
+}
+
+// Shutdown stops the pprof server and flushes the last profiles to Pyroscope.
+func (p *Profiler) Shutdown(ctx context.Context) error {
+	var errs []error
+	if p.server != nil {
+		errs = append(errs, p.server.Shutdown(ctx))
+	}
+	if p.pyroscope != nil {
+		// Flush czeka na wysyłkę – przy niedostępnym serwerze ogranicza go ctx
+		flushed := make(chan struct{})
+		go func() {
+			p.pyroscope.Flush(true)
+			close(flushed)
+		}()
+		select {
+		case <-flushed:
+		case <-ctx.Done():
+			errs = append(errs, ctx.Err())
+		}
+		errs = append(errs, p.pyroscope.Stop())
+	}
+	return errors.Join(errs...)
+}
+
+// pprofHandler serves the net/http/pprof endpoints under /debug/pprof/ without
+// touching http.DefaultServeMux.
+func pprofHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return mux
+}
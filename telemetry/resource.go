@@ -0,0 +1,122 @@
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"runtime/debug"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/resource"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// Build metadata, set at link time by the Dockerfiles:
+//
+//	-ldflags "-X Service-sharing-environment-project/telemetry.version=0.1.0
+//	          -X Service-sharing-environment-project/telemetry.revision=<git sha>"
+//
+// When empty, both are read from the Go build info (module version and
+// vcs.revision stamped by go build).
+var (
+	version  string
+	revision string
+)
+
+// Downward-API environment variables describing the Kubernetes pod
+// (set by the Helm chart).
+const (
+	envK8SPodName       = "K8S_POD_NAME"
+	envK8SPodUID        = "K8S_POD_UID"
+	envK8SNamespaceName = "K8S_NAMESPACE_NAME"
+	envK8SNodeName      = "K8S_NODE_NAME"
+)
+
+// vcsRevisionKey is the commit the binary was built from.
+const vcsRevisionKey = attribute.Key("vcs.ref.head.revision")
+
+// newResource builds the resource shared by traces, metrics and logs. Later
+// sources override earlier ones: build info, host/OS/process/container
+// detectors, the Kubernetes downward API, OTEL_RESOURCE_ATTRIBUTES and
+// finally the service name and environment from cfg. A detector that fails
+// (e.g. no container id outside a container) only drops its attributes.
+func newResource(ctx context.Context, cfg Config) (*resource.Resource, error) {
+	res, err := resource.New(ctx,
+		// Schemat zgodny z detektorami SDK – inaczej Merge zgłasza konflikt schema URL
+		resource.WithSchemaURL(semconv.SchemaURL),
+		resource.WithTelemetrySDK(),
+		resource.WithAttributes(buildAttributes()...),
+		resource.WithHost(),
+		resource.WithOSType(),
+		resource.WithProcessPID(),
+		resource.WithProcessExecutableName(),
+		resource.WithProcessRuntimeName(),
+		resource.WithProcessRuntimeVersion(),
+		resource.WithContainer(),
+		resource.WithAttributes(kubernetesAttributes()...),
+		resource.WithFromEnv(),
+		resource.WithAttributes(serviceAttributes(cfg)...),
+	)
+	if errors.Is(err, resource.ErrPartialResource) {
+		slog.Warn("resource detection incomplete", slog.Any("error", err))
+		return res, nil
+	}
+	return res, err
+}
+
+// buildAttributes returns service.version and the VCS revision of the binary.
+func buildAttributes() []attribute.KeyValue {
+	ver, rev := version, revision
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if ver == "" && info.Main.Version != "" && info.Main.Version != "(devel)" {
+			ver = info.Main.Version
+		}
+		for _, s := range info.Settings {
+			if s.Key == "vcs.revision" && rev == "" {
+				rev = s.Value
+			}
+		}
+	}
+
+	var attrs []attribute.KeyValue
+	if ver != "" {
+		attrs = append(attrs, semconv.ServiceVersion(ver))
+	}
+	if rev != "" {
+		attrs = append(attrs, vcsRevisionKey.String(rev))
+	}
+	return attrs
+}
+
+// kubernetesAttributes maps the downward-API variables that are set to k8s.* attributes.
+func kubernetesAttributes() []attribute.KeyValue {
+	var attrs []attribute.KeyValue
+	for _, e := range []struct {
+		env string
+		key attribute.Key
+	}{
+		{envK8SPodName, semconv.K8SPodNameKey},
+		{envK8SPodUID, semconv.K8SPodUIDKey},
+		{envK8SNamespaceName, semconv.K8SNamespaceNameKey},
+		{envK8SNodeName, semconv.K8SNodeNameKey},
+	} {
+		if v := os.Getenv(e.env); v != "" {
+			attrs = append(attrs, e.key.String(v))
+		}
+	}
+	return attrs
+}
+
+// serviceAttributes returns the attributes taken from cfg; the environment
+// is only set when configured, so OTEL_RESOURCE_ATTRIBUTES can provide it.
+func serviceAttributes(cfg Config) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{semconv.ServiceName(cfg.ServiceName)}
+	if cfg.Environment != "" {
+		attrs = append(attrs,
+			semconv.DeploymentEnvironment(cfg.Environment),
+			attribute.String("environment", cfg.Environment),
+		)
+	}
+	return attrs
+}
@@ -0,0 +1,175 @@
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Sampler names, as accepted by OTEL_TRACES_SAMPLER.
+const (
+	SamplerAlwaysOn                = "always_on"
+	SamplerAlwaysOff               = "always_off"
+	SamplerTraceIDRatio            = "traceidratio"
+	SamplerParentBasedAlwaysOn     = "parentbased_always_on"
+	SamplerParentBasedAlwaysOff    = "parentbased_always_off"
+	SamplerParentBasedTraceIDRatio = "parentbased_traceidratio"
+)
+
+// SamplingConfig selects the trace sampler.
+type SamplingConfig struct {
+	// Sampler is one of the OTEL_TRACES_SAMPLER names (default parentbased_always_on).
+	Sampler string `yaml:"sampler"`
+	// Ratio is the sampling probability of the *traceidratio samplers (OTEL_TRACES_SAMPLER_ARG).
+	Ratio float64 `yaml:"ratio"`
+	// Overrides maps an RPC method (matched against the end of the span name,
+	// e.g. "FinalizeOrder") to its own sampling probability for root spans.
+	Overrides map[string]float64 `yaml:"overrides"`
+	// AlwaysSampleErrors exports spans that end with an error status even when
+	// the sampler dropped their trace.
+	AlwaysSampleErrors bool `yaml:"always_sample_errors"`
+}
+
+// Validate checks the sampler name and that every probability is within [0, 1].
+func (c SamplingConfig) Validate() error {
+	var errs []error
+	switch c.Sampler {
+	case "", SamplerAlwaysOn, SamplerAlwaysOff, SamplerTraceIDRatio,
+		SamplerParentBasedAlwaysOn, SamplerParentBasedAlwaysOff, SamplerParentBasedTraceIDRatio:
+	default:
+		errs = append(errs, fmt.Errorf("unknown sampler %q", c.Sampler))
+	}
+	if c.Ratio < 0 || c.Ratio > 1 {
+		errs = append(errs, fmt.Errorf("sampler ratio %v must be within [0, 1]", c.Ratio))
+	}
+	for method, ratio := range c.Overrides {
+		if ratio < 0 || ratio > 1 {
+			errs = append(errs, fmt.Errorf("sampler override %s=%v must be within [0, 1]", method, ratio))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// newSampler builds the configured sampler. Overrides apply to root spans in
+// place of the base sampler; parent-based samplers keep following the parent.
+// With AlwaysSampleErrors, dropped spans are still recorded (RecordOnly) so
+// that errorSampler can export the ones that fail.
+func newSampler(c SamplingConfig) sdktrace.Sampler {
+	var root sdktrace.Sampler
+	switch c.Sampler {
+	case SamplerAlwaysOff, SamplerParentBasedAlwaysOff:
+		root = sdktrace.NeverSample()
+	case SamplerTraceIDRatio, SamplerParentBasedTraceIDRatio:
+		root = sdktrace.TraceIDRatioBased(c.Ratio)
+	default:
+		root = sdktrace.AlwaysSample()
+	}
+	if len(c.Overrides) > 0 {
+		root = newOverrideSampler(root, c.Overrides)
+	}
+
+	var sampler sdktrace.Sampler
+	switch c.Sampler {
+	case SamplerAlwaysOn, SamplerAlwaysOff, SamplerTraceIDRatio:
+		sampler = root
+	default:
+		sampler = sdktrace.ParentBased(root)
+	}
+	if c.AlwaysSampleErrors {
+		sampler = recordOnlySampler{next: sampler}
+	}
+	return sampler
+}
+
+type methodSampler struct {
+	method  string
+	sampler sdktrace.Sampler
+}
+
+// overrideSampler picks a per-method sampler by span name suffix.
+type overrideSampler struct {
+	base    sdktrace.Sampler
+	methods []methodSampler
+}
+
+func newOverrideSampler(base sdktrace.Sampler, overrides map[string]float64) overrideSampler {
+	s := overrideSampler{base: base}
+	for method, ratio := range overrides {
+		s.methods = append(s.methods, methodSampler{method: method, sampler: sdktrace.TraceIDRatioBased(ratio)})
+	}
+	sort.Slice(s.methods, func(i, j int) bool { return s.methods[i].method < s.methods[j].method })
+	return s
+}
+
+func (s overrideSampler) ShouldSample(p sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	for _, m := range s.methods {
+		if p.Name == m.method || strings.HasSuffix(p.Name, "/"+m.method) {
+			return m.sampler.ShouldSample(p)
+		}
+	}
+	return s.base.ShouldSample(p)
+}
+
+func (s overrideSampler) Description() string {
+	parts := make([]string, 0, len(s.methods))
+	for _, m := range s.methods {
+		parts = append(parts, m.method+"="+m.sampler.Description())
+	}
+	return fmt.Sprintf("MethodOverrides{%s,base:%s}", strings.Join(parts, ","), s.base.Description())
+}
+
+// recordOnlySampler turns Drop decisions into RecordOnly, so dropped spans
+// still reach the span processors (but not the exporter).
+type recordOnlySampler struct {
+	next sdktrace.Sampler
+}
+
+func (s recordOnlySampler) ShouldSample(p sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	res := s.next.ShouldSample(p)
+	if res.Decision == sdktrace.Drop {
+		res.Decision = sdktrace.RecordOnly
+	}
+	return res
+}
+
+func (s recordOnlySampler) Description() string {
+	return "RecordOnDrop{" + s.next.Description() + "}"
+}
+
+// errorSampler forwards sampled spans to next unchanged and, in addition,
+// unsampled spans that ended with an error, marked as sampled.
+type errorSampler struct {
+	next sdktrace.SpanProcessor
+}
+
+func (p errorSampler) OnStart(parent context.Context, s sdktrace.ReadWriteSpan) {
+	p.next.OnStart(parent, s)
+}
+
+func (p errorSampler) OnEnd(s sdktrace.ReadOnlySpan) {
+	switch {
+	case s.SpanContext().IsSampled():
+		p.next.OnEnd(s)
+	case s.Status().Code == codes.Error:
+		p.next.OnEnd(sampledSpan{ReadOnlySpan: s})
+	}
+}
+
+func (p errorSampler) Shutdown(ctx context.Context) error   { return p.next.Shutdown(ctx) }
+func (p errorSampler) ForceFlush(ctx context.Context) error { return p.next.ForceFlush(ctx) }
+
+// sampledSpan reports the wrapped span as sampled so the batch processor exports it.
+type sampledSpan struct {
+	sdktrace.ReadOnlySpan
+}
+
+func (s sampledSpan) SpanContext() trace.SpanContext {
+	sc := s.ReadOnlySpan.SpanContext()
+	return sc.WithTraceFlags(sc.TraceFlags().WithSampled(true))
+}
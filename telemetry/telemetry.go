@@ -2,92 +2,169 @@ package telemetry
 
 import (
 	"context"
-	"os"
 
+	otelpyroscope "github.com/grafana/otel-profiling-go"
+	"go.opentelemetry.io/contrib/instrumentation/host"
+	"go.opentelemetry.io/contrib/instrumentation/runtime"
 	"go.opentelemetry.io/otel"
-	"go.opentelemetry.io/otel/attribute"
-	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
-	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
-	apiMetric "go.opentelemetry.io/otel/metric"
 	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
-	"go.opentelemetry.io/otel/sdk/resource"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
-	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
 )
 
-// InitTracer sets up an OTLP trace exporter (gRPC) and installs a TracerProvider.
-func InitTracer(ctx context.Context, serviceName string) (*sdktrace.TracerProvider, error) {
-	endpoint := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
-	if endpoint == "" {
-		endpoint = "localhost:4317"
+// DefaultOTLPEndpoint is used when Config.OTLPEndpoint is empty and the
+// protocol is grpc.
+const DefaultOTLPEndpoint = "localhost:4317"
+
+// Config holds the settings shared by the tracer, meter and logger providers.
+type Config struct {
+	ServiceName string `yaml:"service_name"`
+	// OTLPEndpoint is host:port or a URL (http:// or https://) of the collector.
+	OTLPEndpoint string `yaml:"otlp_endpoint"`
+	Environment  string `yaml:"environment"`
+	// LogLevel is the minimum slog level: debug, info, warn or error (default info).
+	LogLevel string `yaml:"log_level"`
+	// ExemplarFilter selects which measurements carry exemplars: trace_based
+	// (default), always_on or always_off.
+	ExemplarFilter string `yaml:"exemplar_filter"`
+
+	// SpanBaggageKeys lists baggage entries promoted to span attributes
+	// (nil means DefaultSpanBaggageKeys).
+	SpanBaggageKeys []string `yaml:"span_baggage_keys"`
+	// MetricBaggageKeys lists baggage entries added to RPC metric attributes
+	// (nil means DefaultMetricBaggageKeys).
+	MetricBaggageKeys []string `yaml:"metric_baggage_keys"`
+
+	Exporters ExportersConfig `yaml:"exporters"`
+	Sampling  SamplingConfig  `yaml:"sampling"`
+	Profiling ProfilingConfig `yaml:"profiling"`
+}
+
+func (c Config) endpoint() string {
+	switch {
+	case c.OTLPEndpoint != "":
+		return c.OTLPEndpoint
+	case c.Exporters.OTLP.Protocol == OTLPProtocolHTTP:
+		return DefaultOTLPHTTPEndpoint
+	default:
+		return DefaultOTLPEndpoint
+	}
+}
+
+func (c Config) logLevel() string {
+	if c.LogLevel == "" {
+		return "info"
 	}
+	return c.LogLevel
+}
+
+// MetricKeys returns the baggage keys to record on metrics.
+func (c Config) MetricKeys() []string {
+	if c.MetricBaggageKeys == nil {
+		return DefaultMetricBaggageKeys
+	}
+	return c.MetricBaggageKeys
+}
 
-	// Create OTLP‐trace exporter over gRPC (insecure)
-	traceExp, err := otlptracegrpc.New(ctx,
-		otlptracegrpc.WithEndpoint(endpoint),
-		otlptracegrpc.WithDialOption(grpc.WithTransportCredentials(insecure.NewCredentials())),
-	)
+func (c Config) spanKeys() []string {
+	if c.SpanBaggageKeys == nil {
+		return DefaultSpanBaggageKeys
+	}
+	return c.SpanBaggageKeys
+}
+
+// InitTracer sets up the span exporter selected by cfg.Exporters.Traces (OTLP
+// over gRPC by default), installs a TracerProvider and the W3C trace context
+// + baggage propagator, so that one trace (and its baggage) spans every
+// service a request passes through. With profiling enabled the global
+// provider links spans to their profiles.
+func InitTracer(ctx context.Context, cfg Config) (*sdktrace.TracerProvider, error) {
+	// Eksporter spanów wg konfiguracji (OTLP gRPC/HTTP, stdout lub brak)
+	traceExp, err := newSpanExporter(ctx, cfg)
 	if err != nil {
 		return nil, err
 	}
 
-	res, err := resource.New(ctx,
-		resource.WithAttributes(
-			semconv.ServiceNameKey.String(serviceName),
-			attribute.String("environment", os.Getenv("ENVIRONMENT")),
-		),
-	)
+	res, err := newResource(ctx, cfg)
 	if err != nil {
 		return nil, err
 	}
 
-	tp := sdktrace.NewTracerProvider(
-		sdktrace.WithBatcher(traceExp),
+	if err := cfg.Sampling.Validate(); err != nil {
+		return nil, err
+	}
+	opts := []sdktrace.TracerProviderOption{
+		sdktrace.WithSampler(newSampler(cfg.Sampling)),
+		sdktrace.WithSpanProcessor(baggageSpanProcessor{keys: cfg.spanKeys()}),
 		sdktrace.WithResource(res),
-	)
-	otel.SetTracerProvider(tp)
+	}
+	// Bez eksportera spany nadal powstają – propagacja kontekstu i trace_id w logach działają
+	if traceExp != nil {
+		// Spany odrzucone przez sampler, ale zakończone błędem, i tak trafiają do eksportu
+		var exportProcessor sdktrace.SpanProcessor = sdktrace.NewBatchSpanProcessor(traceExp)
+		if cfg.Sampling.AlwaysSampleErrors {
+			exportProcessor = errorSampler{next: exportProcessor}
+		}
+		opts = append(opts, sdktrace.WithSpanProcessor(exportProcessor))
+	}
+
+	tp := sdktrace.NewTracerProvider(opts...)
+	if cfg.Profiling.Enabled() {
+		// Spany lokalnego korzenia oznaczają próbki pprof etykietami span_id/span_name
+		// i dostają atrybut pyroscope.profile.id, po którym Tempo otwiera profil
+		otel.SetTracerProvider(otelpyroscope.NewTracerProvider(tp))
+	} else {
+		otel.SetTracerProvider(tp)
+	}
+	otel.SetTextMapPropagator(Propagator())
 	return tp, nil
 }
 
-// InitMetrics sets up an OTLP metric exporter (gRPC) and installs a MeterProvider.
-// Returns the api.MeterProvider; no HTTP handler is returned.
-func InitMetrics(ctx context.Context, serviceName string) (apiMetric.MeterProvider, error) {
-	endpoint := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
-	if endpoint == "" {
-		endpoint = "localhost:4317"
+// InitMetrics sets up the metric exporter selected by cfg.Exporters.Metrics
+// (OTLP over gRPC by default; prometheus serves a /metrics endpoint for
+// scraping) and installs a MeterProvider whose latency histograms use
+// LatencyBuckets and carry exemplars (trace and span ids) selected by
+// cfg.ExemplarFilter. Go runtime (heap, GC, goroutines, scheduling latency)
+// and process/host (CPU, memory, network) metrics are collected as well.
+// The SDK provider is returned so that callers can flush it with Shutdown on exit.
+func InitMetrics(ctx context.Context, cfg Config) (*sdkmetric.MeterProvider, error) {
+	filter, err := newExemplarFilter(cfg.ExemplarFilter)
+	if err != nil {
+		return nil, err
 	}
 
-	// 1) Tworzymy OTLP metric exporter
-	metricExp, err := otlpmetricgrpc.New(ctx,
-		otlpmetricgrpc.WithEndpoint(endpoint),
-		otlpmetricgrpc.WithDialOption(grpc.WithTransportCredentials(insecure.NewCredentials())),
-	)
+	// 1) Budujemy zasób (resource) z nazwą usługi i środowiskiem
+	res, err := newResource(ctx, cfg)
 	if err != nil {
 		return nil, err
 	}
 
-	// 2) Budujemy zasób (resource) z nazwą usługi i środowiskiem
-	res, err := resource.New(ctx,
-		resource.WithAttributes(
-			semconv.ServiceNameKey.String(serviceName),
-			attribute.String("environment", os.Getenv("ENVIRONMENT")),
-		),
-	)
+	// 2) Reader eksportera wg konfiguracji: PeriodicReader owijający eksporter
+	//    OTLP/stdout albo reader Prometheusa odpytywany przez scrape
+	reader, err := newMetricReader(ctx, cfg)
 	if err != nil {
 		return nil, err
 	}
 
-	// 3) Używamy PeriodicReader, który *owija* metricExp i implementuje Reader
-	reader := sdkmetric.NewPeriodicReader(metricExp)
-
-	// 4) Tworzymy MeterProvider z PeriodicReader, a nie samym metricExp
-	mp := sdkmetric.NewMeterProvider(
-		sdkmetric.WithReader(reader),
+	// 3) Tworzymy MeterProvider z readerem (bez readera metryki nie są eksportowane);
+	//    exemplary i kubełki histogramów opóźnień wg filtra i widoków
+	opts := []sdkmetric.Option{
 		sdkmetric.WithResource(res),
-	)
+		sdkmetric.WithExemplarFilter(filter),
+		sdkmetric.WithView(latencyViews()...),
+	}
+	if reader != nil {
+		opts = append(opts, sdkmetric.WithReader(reader))
+	}
+	mp := sdkmetric.NewMeterProvider(opts...)
 	otel.SetMeterProvider(mp)
 
+	// 4) Metryki runtime Go (sterta, GC, gorutyny) oraz procesu i hosta (CPU, pamięć, sieć)
+	if err := runtime.Start(runtime.WithMeterProvider(mp)); err != nil {
+		return nil, err
+	}
+	if err := host.Start(host.WithMeterProvider(mp)); err != nil {
+		return nil, err
+	}
+
 	return mp, nil
 }
@@ -0,0 +1,329 @@
+// Package walstore keeps in-memory state durable as a write-ahead log plus
+// periodic snapshots. Every change is appended to the log (and fsynced) before
+// it is applied; a snapshot atomically rewrites the full state and truncates
+// the log. On open the state is restored from the snapshot and the log, and an
+// incomplete last record left by a crash is discarded.
+//
+// Log records carry a sequence number and the snapshot stores the last one it
+// includes, so records still in the log after a crash between writing the
+// snapshot and truncating the log are skipped rather than applied twice.
+package walstore
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultSnapshotInterval is used when Options.SnapshotInterval is not positive.
+const DefaultSnapshotInterval = time.Minute
+
+// snapshotHeader starts the first line of a snapshot file, followed by the
+// sequence number of the last log record the snapshot includes.
+const snapshotHeader = "walstore-seq "
+
+// State is the in-memory state a Log keeps durable.
+type State interface {
+	// Restore loads a snapshot produced by Snapshot.
+	Restore(snapshot []byte) error
+	// Replay applies one log record. An error fails Open: only an incomplete
+	// last record is treated as a crash leftover and discarded.
+	Replay(record []byte) error
+	// Snapshot encodes the full state.
+	Snapshot() ([]byte, error)
+}
+
+// Options configures a Log.
+type Options struct {
+	// Dir holds the snapshot and the log; it is created if missing.
+	Dir string
+	// SnapshotFile and WALFile are the file names within Dir.
+	SnapshotFile string
+	WALFile      string
+	// SnapshotInterval is how often a snapshot is taken.
+	SnapshotInterval time.Duration
+	// Component labels the log records written by the Log.
+	Component string
+}
+
+// Log is the write-ahead log and snapshot files of one State.
+type Log struct {
+	mu      sync.Mutex
+	opts    Options
+	state   State
+	wal     *os.File
+	entries int
+	// seq is the sequence number of the last record applied to the state;
+	// snapSeq that of the last record included in the snapshot on disk
+	seq, snapSeq uint64
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// Open restores state from opts.Dir and starts the periodic snapshots.
+func Open(opts Options, state State) (*Log, error) {
+	if opts.Dir == "" {
+		return nil, errors.New("file store requires a data directory")
+	}
+	if opts.SnapshotInterval <= 0 {
+		opts.SnapshotInterval = DefaultSnapshotInterval
+	}
+	if err := os.MkdirAll(opts.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create data dir: %w", err)
+	}
+
+	l := &Log{
+		opts:  opts,
+		state: state,
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	if err := l.loadSnapshot(); err != nil {
+		return nil, err
+	}
+	if err := l.replay(); err != nil {
+		return nil, err
+	}
+
+	go l.snapshotLoop()
+	return l, nil
+}
+
+// Entries returns the number of records in the log since the last snapshot.
+func (l *Log) Entries() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.entries
+}
+
+// Update runs fn under the log lock. fn validates the change, makes it durable
+// with appendRecord and then applies it to the state, so changes reach the state
+// in log order and a rejected change never reaches the log.
+func (l *Log) Update(fn func(appendRecord func(record []byte) error) error) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return fn(l.append)
+}
+
+// Snapshot writes the full state to disk and truncates the log.
+func (l *Log) Snapshot() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.snapshotLocked()
+}
+
+// Close stops the periodic snapshots, writes a final snapshot and closes the log.
+func (l *Log) Close() error {
+	select {
+	case <-l.stop:
+		return nil
+	default:
+		close(l.stop)
+	}
+	<-l.done
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	err := l.snapshotLocked()
+	if cerr := l.wal.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (l *Log) snapshotLoop() {
+	defer close(l.done)
+	ticker := time.NewTicker(l.opts.SnapshotInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			if err := l.Snapshot(); err != nil {
+				slog.Error("snapshot failed", slog.String("component", l.opts.Component), slog.Any("error", err))
+			}
+		}
+	}
+}
+
+func (l *Log) snapshotLocked() error {
+	if l.entries == 0 {
+		return nil
+	}
+
+	state, err := l.state.Snapshot()
+	if err != nil {
+		return err
+	}
+	data := append([]byte(snapshotHeader+strconv.FormatUint(l.seq, 10)+"\n"), state...)
+
+	// Write to a temporary file and rename it; only then truncate the log.
+	// Records left in the log by a crash in between are skipped by replay.
+	tmp := filepath.Join(l.opts.Dir, l.opts.SnapshotFile+".tmp")
+	if err := writeFileSync(tmp, data); err != nil {
+		return fmt.Errorf("write snapshot: %w", err)
+	}
+	if err := os.Rename(tmp, filepath.Join(l.opts.Dir, l.opts.SnapshotFile)); err != nil {
+		return fmt.Errorf("rename snapshot: %w", err)
+	}
+	if err := syncDir(l.opts.Dir); err != nil {
+		return err
+	}
+
+	if err := l.wal.Truncate(0); err != nil {
+		return fmt.Errorf("truncate wal: %w", err)
+	}
+	if _, err := l.wal.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	slog.Info("snapshot written",
+		slog.String("component", l.opts.Component),
+		slog.Int("wal_entries", l.entries),
+	)
+	l.entries = 0
+	l.snapSeq = l.seq
+	return nil
+}
+
+// append writes record as one "<seq>\t<record>" line
+func (l *Log) append(record []byte) error {
+	line := strconv.AppendUint(nil, l.seq+1, 10)
+	line = append(append(append(line, '\t'), record...), '\n')
+	if _, err := l.wal.Write(line); err != nil {
+		return fmt.Errorf("append wal: %w", err)
+	}
+	if err := l.wal.Sync(); err != nil {
+		return fmt.Errorf("sync wal: %w", err)
+	}
+	l.seq++
+	l.entries++
+	return nil
+}
+
+func (l *Log) loadSnapshot() error {
+	data, err := os.ReadFile(filepath.Join(l.opts.Dir, l.opts.SnapshotFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read snapshot: %w", err)
+	}
+	// Snapshots written before sequence numbers have no header and include no record
+	if rest, ok := bytes.CutPrefix(data, []byte(snapshotHeader)); ok {
+		header, state, _ := bytes.Cut(rest, []byte("\n"))
+		seq, err := strconv.ParseUint(string(header), 10, 64)
+		if err != nil {
+			return fmt.Errorf("decode snapshot header: %w", err)
+		}
+		l.seq, l.snapSeq, data = seq, seq, state
+	}
+	if err := l.state.Restore(data); err != nil {
+		return fmt.Errorf("decode snapshot: %w", err)
+	}
+	return nil
+}
+
+// replay applies the log records newer than the snapshot. An incomplete last
+// record (a crash during a write) is discarded and the file truncated after the
+// last complete one; a complete record that cannot be applied fails Open.
+func (l *Log) replay() error {
+	f, err := os.OpenFile(filepath.Join(l.opts.Dir, l.opts.WALFile), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("open wal: %w", err)
+	}
+
+	var offset int64
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF && len(line) == 0 {
+			break
+		}
+		if err != nil && err != io.EOF {
+			f.Close()
+			return fmt.Errorf("read wal: %w", err)
+		}
+		if err == io.EOF {
+			slog.Warn("discarding incomplete wal record", slog.String("component", l.opts.Component), slog.Int64("offset", offset))
+			break
+		}
+		if err := l.replayLine(bytes.TrimSpace(line)); err != nil {
+			f.Close()
+			return fmt.Errorf("replay wal record at offset %d: %w", offset, err)
+		}
+		offset += int64(len(line))
+		l.entries++
+	}
+
+	if err := f.Truncate(offset); err != nil {
+		f.Close()
+		return fmt.Errorf("truncate wal: %w", err)
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return err
+	}
+	l.wal = f
+	return nil
+}
+
+// replayLine applies one "<seq>\t<record>" line unless the snapshot already
+// includes it; lines written before sequence numbers are plain records
+func (l *Log) replayLine(line []byte) error {
+	seq := l.seq + 1
+	record := line
+	if prefix, rest, ok := bytes.Cut(line, []byte("\t")); ok {
+		n, err := strconv.ParseUint(string(prefix), 10, 64)
+		if err == nil {
+			seq, record = n, rest
+		}
+	}
+	if seq <= l.snapSeq {
+		return nil
+	}
+	if seq != l.seq+1 {
+		return fmt.Errorf("sequence %d after %d", seq, l.seq)
+	}
+	if err := l.state.Replay(record); err != nil {
+		return err
+	}
+	l.seq = seq
+	return nil
+}
+
+func writeFileSync(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,190 @@
+package walstore
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// sumState is a running total; each log record is a delta.
+type sumState struct {
+	total int
+}
+
+func (s *sumState) Restore(data []byte) error {
+	return json.Unmarshal(data, &s.total)
+}
+
+func (s *sumState) Replay(record []byte) error {
+	delta, err := strconv.Atoi(string(record))
+	if err != nil {
+		return err
+	}
+	s.total += delta
+	return nil
+}
+
+func (s *sumState) Snapshot() ([]byte, error) {
+	return json.Marshal(s.total)
+}
+
+func openSum(t *testing.T, dir string) (*Log, *sumState) {
+	t.Helper()
+	state := &sumState{}
+	l, err := Open(Options{Dir: dir, SnapshotFile: "snap.json", WALFile: "wal.log", SnapshotInterval: time.Hour, Component: "test"}, state)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	return l, state
+}
+
+func add(t *testing.T, l *Log, s *sumState, delta int) {
+	t.Helper()
+	err := l.Update(func(appendRecord func([]byte) error) error {
+		if err := appendRecord([]byte(strconv.Itoa(delta))); err != nil {
+			return err
+		}
+		s.total += delta
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+}
+
+func TestOpenRequiresDir(t *testing.T) {
+	if _, err := Open(Options{}, &sumState{}); err == nil {
+		t.Fatal("Open without Dir: want error")
+	}
+}
+
+func TestLogRecoversSnapshotAndWAL(t *testing.T) {
+	dir := t.TempDir()
+	l, s := openSum(t, dir)
+	add(t, l, s, 10)
+	if err := l.Snapshot(); err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+	add(t, l, s, -3)
+
+	// Open a copy of the files while the log is still open, as after a crash
+	crashed := t.TempDir()
+	for _, name := range []string{"snap.json", "wal.log"} {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("read %s: %v", name, err)
+		}
+		if err := os.WriteFile(filepath.Join(crashed, name), data, 0o644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+	if err := l.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	l, s = openSum(t, crashed)
+	defer l.Close()
+	if s.total != 7 {
+		t.Errorf("total %d, want 7", s.total)
+	}
+	if got := l.Entries(); got != 1 {
+		t.Errorf("Entries() = %d, want 1", got)
+	}
+}
+
+func TestLogDiscardsIncompleteTail(t *testing.T) {
+	dir := t.TempDir()
+	walPath := filepath.Join(dir, "wal.log")
+	// Records from before sequence numbers, then one torn by a crash
+	if err := os.WriteFile(walPath, []byte("5\n2\n3\t4"), 0o644); err != nil {
+		t.Fatalf("write wal: %v", err)
+	}
+
+	l, s := openSum(t, dir)
+	if s.total != 7 {
+		t.Errorf("total %d, want 7", s.total)
+	}
+	add(t, l, s, 1)
+	if err := l.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	l, s = openSum(t, dir)
+	defer l.Close()
+	if s.total != 8 {
+		t.Errorf("total after reopen %d, want 8", s.total)
+	}
+}
+
+func TestOpenFailsOnCorruptRecord(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "wal.log"), []byte("1\t5\n2\tbad\n3\t4\n"), 0o644); err != nil {
+		t.Fatalf("write wal: %v", err)
+	}
+	if _, err := Open(Options{Dir: dir, SnapshotFile: "snap.json", WALFile: "wal.log"}, &sumState{}); err == nil {
+		t.Fatal("Open with a corrupt record in the middle: want error")
+	}
+	// Valid records after the corrupt one stay on disk
+	data, err := os.ReadFile(filepath.Join(dir, "wal.log"))
+	if err != nil {
+		t.Fatalf("read wal: %v", err)
+	}
+	if string(data) != "1\t5\n2\tbad\n3\t4\n" {
+		t.Errorf("wal rewritten to %q", data)
+	}
+}
+
+func TestLogSkipsRecordsIncludedInSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	walPath := filepath.Join(dir, "wal.log")
+	l, s := openSum(t, dir)
+	add(t, l, s, 10)
+	add(t, l, s, -3)
+	wal, err := os.ReadFile(walPath)
+	if err != nil {
+		t.Fatalf("read wal: %v", err)
+	}
+	if err := l.Snapshot(); err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+	add(t, l, s, 1)
+	if err := l.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	// A crash after the snapshot rename but before the truncate leaves the old log
+	if err := os.WriteFile(walPath, wal, 0o644); err != nil {
+		t.Fatalf("write wal: %v", err)
+	}
+	l, s = openSum(t, dir)
+	if s.total != 8 {
+		t.Errorf("total %d, want 8", s.total)
+	}
+	add(t, l, s, 2)
+	if err := l.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	l, s = openSum(t, dir)
+	defer l.Close()
+	if s.total != 10 {
+		t.Errorf("total after reopen %d, want 10", s.total)
+	}
+}
+
+func TestUpdateErrorSkipsState(t *testing.T) {
+	l, s := openSum(t, t.TempDir())
+	defer l.Close()
+	errRejected := errors.New("rejected")
+	err := l.Update(func(func([]byte) error) error { return errRejected })
+	if !errors.Is(err, errRejected) {
+		t.Errorf("Update error %v, want %v", err, errRejected)
+	}
+	if l.Entries() != 0 || s.total != 0 {
+		t.Errorf("rejected update reached the log: entries %d, total %d", l.Entries(), s.total)
+	}
+}